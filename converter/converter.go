@@ -2,14 +2,13 @@ package converter
 
 import (
 	"bufio"
-	"errors"
 	"fmt"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/fengdu/billconverter/util"
+	"github.com/shopspring/decimal"
 )
 
 // BillBaseInfo bill base info from src file
@@ -26,10 +25,14 @@ func GetBillBaseInfo(content string) (BillBaseInfo, error) {
 
 	header, err := readHeadSegment(content)
 	if err != nil {
-		return result, fmt.Errorf("ERROR: readHeadSegment: %v", err)
+		return result, err
 	}
 
 	result.AccountNo = header["Account No"]
+	if result.AccountNo == "" {
+		return result, &ParseError{Segment: "Account No", Msg: "account no not found"}
+	}
+
 	if b, ok := header["Bill Date"]; ok {
 		if t, err := time.Parse("2006-01-02", b); err == nil {
 			result.BillDate = t
@@ -51,171 +54,115 @@ func GetBillBaseInfo(content string) (BillBaseInfo, error) {
 	return result, nil
 }
 
-// GetBalances parse Financial Situation segment
-func GetBalances(bill BillBaseInfo, content string) [][]string {
-	s := util.ParseSegment(content, "Financial Situation")
-
-	set := make(map[string][]string)
-	for _, r := range readSegment(s) {
-		set[strings.TrimSpace(r[0])] = r
-	}
+// Column indexes of the Pos and Trades csv layout of the default template
+const (
+	ColAccount = iota
+	ColTradedate
+	ColLong
+	ColShort
+	ColFutOpt
+	ColExchange
+	ColContract
+	ColContractMonth
+	ColContractYear
+	ColStrikePrice
+	ColPrice
+	ColSettPrice
+	ColCurrency
+	ColUnrealisedPL
+	ColTradeNo
+	ColBuySell
+	ColSubType
+	ColCommodity
+	ColCommission
+	ColOptionDelta
+	ColFirmOffice
+	ColAsOfDate
+)
 
-	result := [][]string{
-		{
-			"Account", "Currency", "BalanceBf", "Deposit", "Withdrawal",
-			"OptionPremium", "DeliveryProceed", "RealisedPL", "Commission", "Interest",
-			"Others", "BalanceCf", "UnrealisedPL", "Equity", "NetOptionValue",
-			"EligCollateral", "as-of-date mm/dd/yyyy",
-		},
-	}
+// GetBalances convert Financial Situation of statement to csv rows of the default template
+func GetBalances(st Statement) [][]string {
+	return defaultRows(KindBalances, st)
+}
 
-	Account, Currency, BalanceBf, Deposit, Withdrawal,
-		OptionPremium, DeliveryProceed, RealisedPL, Commission, Interest,
-		Others, BalanceCf, UnrealisedPL, Equity, NetOptionValue,
-		EligCollateral, asofdate :=
-		bill.AccountNo, "CNY", "Opening", "", "",
-		"", "", "Opening - Closing", "", "",
-		"", "Closing", "", "", "",
-		"", bill.BillDate.Format("01/02/2006")
-
-	if v, ok := set["Deposit/Withdrawal"]; ok {
-		if len(v) >= 3 {
-			o := strings.Replace(strings.TrimSpace(v[2]), ",", "", -1)
-			if f, err := strconv.ParseFloat(o, 32); err == nil {
-				if f > 0 {
-					Deposit = strconv.FormatFloat(f, 'f', 2, 32)
-				} else {
-					Withdrawal = strconv.FormatFloat(f, 'f', 2, 32)
-				}
-			}
-		}
-	}
+// GetPos convert Gathered Open Positions of statement to csv rows of the default template
+func GetPos(st Statement) [][]string {
+	return defaultRows(KindPositions, st)
+}
 
-	if v, ok := set["Commissions"]; ok {
-		if len(v) >= 3 {
-			Commission = strings.Replace(strings.TrimSpace(v[2]), ",", "", -1)
-		}
-	}
+// GetOpenLots convert Detailed Open Positions of statement to csv rows of the default template, one row per open lot
+func GetOpenLots(st Statement) [][]string {
+	return defaultRows(KindOpenLots, st)
+}
 
-	if v, ok := set["Unrealized"]; ok {
-		if len(v) >= 3 {
-			UnrealisedPL = strings.Replace(strings.TrimSpace(v[2]), ",", "", -1)
-		}
-	}
+// GetTrades convert Trade Confirmation of statement to csv rows of the default template
+func GetTrades(st Statement) [][]string {
+	return defaultRows(KindTrades, st)
+}
 
-	if v, ok := set["Equity"]; ok {
-		if len(v) >= 3 {
-			Equity = strings.Replace(strings.TrimSpace(v[2]), ",", "", -1)
-		}
-	}
+// GetClosedPositions convert Close Positions of statement to csv rows of the default template, one row per closed lot
+func GetClosedPositions(st Statement) [][]string {
+	return defaultRows(KindRealisedPL, st)
+}
 
-	result = append(result, []string{
-		Account, Currency, BalanceBf, Deposit, Withdrawal,
-		OptionPremium, DeliveryProceed, RealisedPL, Commission, Interest,
-		Others, BalanceCf, UnrealisedPL, Equity, NetOptionValue,
-		EligCollateral, asofdate,
-	})
-
-	return result
-}
-
-// GetPos parse Gathered Open Positions segment
-func GetPos(bill BillBaseInfo, content string) [][]string {
-	s := util.ParseSegment(content, "Gathered Open Positions")
-	result := [][]string{
-		{
-			"Account", "Tradedate", "Long", "Short", "FutOpt",
-			"Exchange", "Contract", "ContractMonth", "Contractyear", "StrikePrice",
-			"Price", "SettPrice", "Currency", "UnrealisedPL", "TradeNo",
-			"BUY/Sell 1=BUY 0=SELL", "SubType P=Put C=Call", "Commodity", "Commission", "Option Delta",
-			"Firm/Office", "as-of-date (mm/dd/yyyy)",
-		},
-	}
+// GetClosedPositionsSummary sum the realised profit of Close Positions by contract
+func GetClosedPositionsSummary(st Statement) [][]string {
+	return defaultRows(KindRealisedPLSummary, st)
+}
 
-	if ss := readSegment(s); len(ss) >= 2 {
-		for _, r := range ss[1 : len(ss)-1] {
-			buy := strings.TrimSpace(r[3])
-			sale := strings.TrimSpace(r[4])
-			market := strings.TrimSpace(r[0])
-			contract := strings.TrimSpace(r[2])
-			contractMonth, contractYear, _ := util.ParseMonthAndYear(contract)
-			matchPrice := strings.TrimSpace(r[5])
-			settlementPrice := strings.TrimSpace(r[6])
-			currency := strings.TrimSpace(r[10])
-			positionProfit := strings.TrimSpace(r[7])
-			product := strings.TrimSpace(r[1])
-
-			result = append(result, []string{
-				bill.AccountNo, bill.StatementDateEnd.Format("2006-01-02"), buy, sale, "F",
-				market, contract, contractMonth, contractYear, "",
-				matchPrice, settlementPrice, currency, positionProfit, "",
-				"", "", product, "", "",
-				"Shanghai Bunge", bill.StatementDateEnd.Format("01/02/2006"),
-			})
-		}
-	}
+// GetJournal convert Journal Description of statement to csv rows of the default template, one row per cash movement
+func GetJournal(st Statement) [][]string {
+	return defaultRows(KindJournal, st)
+}
 
-	return result
+func defaultRows(kind string, st Statement) [][]string {
+	o, _ := DefaultTemplate().Output(kind)
+	return o.Rows(st)
 }
 
-// GetTrades parse Trade Confirmation segment
-func GetTrades(bill BillBaseInfo, content string) [][]string {
-	s := util.ParseSegment(content, "Trade Confirmation")
-	result := [][]string{
-		{
-			"Account", "Tradedate", "Long", "Short", "FutOpt",
-			"Exchange", "Contract", "ContractMonth", "Contractyear", "StrikePrice",
-			"Price", "SettPrice", "Currency", "UnrealisedPL", "TradeNo",
-			"BUY/Sell 1=BUY 0=SELL", "SubType P=Put C=Call", "Commodity", "Commission", "Option Delta",
-			"Firm/Office", "as-of-date (mm/dd/yyyy)",
-		},
+// CheckJournal cross check the Deposit/Withdrawal and Journal of Financial Situation
+// against the sums of the cash and the other Journal Description rows, per currency
+func CheckJournal(st Statement) error {
+	cash := make(map[string]decimal.Decimal)
+	other := make(map[string]decimal.Decimal)
+	for _, j := range st.Journal {
+		sums := other
+		if j.Cash() {
+			sums = cash
+		}
+		sums[j.Currency] = sums[j.Currency].Add(j.CashIn).Sub(j.CashOut)
 	}
 
-	if ss := readSegment(s); len(ss) >= 2 {
-		for _, r := range ss[1 : len(ss)-1] {
-			date := strings.TrimSpace(r[0])
-			matchQty := strings.TrimSpace(r[7])
-			market := strings.TrimSpace(r[1])
-			contract := strings.TrimSpace(r[3])
-			contractMonth, contractYear, _ := util.ParseMonthAndYear(contract)
-			matchPrice := strings.TrimSpace(r[8])
-			currency := strings.TrimSpace(r[11])
-			buySale := strings.TrimSpace(r[6])
-			product := strings.TrimSpace(r[2])
-			fee := strings.TrimSpace(r[10])
-
-			long, short := "0", "0"
-			if buySale == "Sale" {
-				short = matchQty
-			} else if buySale == "Buy" {
-				long = matchQty
-			}
-
-			var asOfDate string
-			if t, err := time.Parse("2006-01-02", date); err == nil {
-				asOfDate = t.Format("01/02/2006")
-			}
-
-			result = append(result, []string{
-				bill.AccountNo, date, long, short, "F",
-				market, contract, contractMonth, contractYear, "",
-				matchPrice, "", currency, "", "",
-				buySale, "", product, fee, "",
-				"Shanghai Bunge", asOfDate,
-			})
+	var mismatches []string
+	for _, b := range st.Balances {
+		if b.Base {
+			continue
+		}
+		if !b.DepositWithdrawal.Equal(cash[b.Currency]) {
+			mismatches = append(mismatches, fmt.Sprintf("%s: Deposit/Withdrawal %s, journal %s",
+				b.Currency, formatAmount(b.DepositWithdrawal), formatAmount(cash[b.Currency])))
 		}
+		if !b.Journal.Equal(other[b.Currency]) {
+			mismatches = append(mismatches, fmt.Sprintf("%s: Journal %s, journal %s",
+				b.Currency, formatAmount(b.Journal), formatAmount(other[b.Currency])))
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("journal mismatch: %s", strings.Join(mismatches, "; "))
 	}
 
-	return result
+	return nil
 }
 
 func readHeadSegment(content string) (map[string]string, error) {
 	s := util.ParseSegment(content, "Account No")
+	first := lineOf(content, s)
 	s = strings.Replace(s, "：", ":", -1)
 
 	result := make(map[string]string)
 	scanner := bufio.NewScanner(strings.NewReader(s))
-	for scanner.Scan() {
+	for n := 0; scanner.Scan(); n++ {
 		line := strings.Trim(strings.TrimSpace(scanner.Text()), "|")
 		if regexp.MustCompile(`^-+`).MatchString(line) {
 			continue
@@ -224,7 +171,7 @@ func readHeadSegment(content string) (map[string]string, error) {
 		for _, f := range p {
 			kv := strings.Split(f, ":")
 			if len(kv) != 2 {
-				return nil, errors.New("Parse bill base info errors")
+				return nil, &ParseError{Segment: "Account No", Line: first + n, Text: f, Msg: "Parse bill base info errors, expect key：value"}
 			}
 			result[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
 		}
@@ -233,17 +180,44 @@ func readHeadSegment(content string) (map[string]string, error) {
 	return result, nil
 }
 
-// readSegment parse table style segment to array
-func readSegment(segment string) [][]string {
-	result := [][]string{}
-	scanner := bufio.NewScanner(strings.NewReader(segment))
-	for scanner.Scan() {
-		line := strings.Trim(strings.TrimSpace(scanner.Text()), "|")
-		if !strings.Contains(line, "|") {
-			continue
+func formatAmount(d decimal.Decimal) string {
+	return d.StringFixed(2)
+}
+
+// formatPrice keep the decimals of the bill, eg: 1706.0000000
+func formatPrice(d decimal.Decimal) string {
+	if d.Exponent() < 0 {
+		return d.StringFixed(-d.Exponent())
+	}
+	return d.String()
+}
+
+// formatDecimal format with a fmt verb, "%.Nf" is exact and the others format
+// the nearest float64. "%'.Nf" groups the thousands like the bill, eg: -236,000.00
+func formatDecimal(d decimal.Decimal, format string) string {
+	verb := strings.Replace(format, "%'", "%", 1)
+	var n int32
+	if _, err := fmt.Sscanf(verb, "%%.%df", &n); err == nil && fmt.Sprintf("%%.%df", n) == verb {
+		if verb != format {
+			return groupThousands(d.StringFixed(n))
 		}
-		result = append(result, strings.Split(line, "|"))
+		return d.StringFixed(n)
 	}
+	return fmt.Sprintf(verb, d.InexactFloat64())
+}
 
-	return result
+// groupThousands insert "," into the integer part of a number
+func groupThousands(s string) string {
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	integer, fraction := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		integer, fraction = s[:i], s[i:]
+	}
+	for i := len(integer) - 3; i > 0; i -= 3 {
+		integer = integer[:i] + "," + integer[i:]
+	}
+	return sign + integer + fraction
 }
@@ -1,7 +1,12 @@
 package converter
 
 import (
+	"fmt"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
 )
 
 var content string
@@ -144,6 +149,7 @@ func init() {
 	|Maintenance       |      1,848,445.00|      1,848,445.00|                  |                  |                  |                  |                  
 	|Excess            |      1,254,233.00|      1,254,233.00|                  |                  |                  |                  |                  
 	|Account           |              0.00|              0.00|                  |                  |                  |                  |                  
+	----------------
 	`
 
 }
@@ -173,3 +179,542 @@ func TestReadHeadSegment(t *testing.T) {
 // 		t.Errorf("Expected records length equal to 9, but got %v", len(m))
 // 	}
 // }
+
+func TestParse(t *testing.T) {
+	st, err := Parse(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Header.AccountNo != "61188805" {
+		t.Errorf("Expected account no 61188805, but got %v", st.Header.AccountNo)
+	}
+	if len(st.Trades) != 7 {
+		t.Errorf("Expected 7 trades, but got %v", len(st.Trades))
+	}
+	if st.Trades[2].MatchQty != 3 || !st.Trades[2].Fee.Equal(decimal.RequireFromString("5.1")) {
+		t.Errorf("Expected trade qty 3 and fee 5.1, but got %v and %v", st.Trades[2].MatchQty, st.Trades[2].Fee)
+	}
+	if len(st.OpenPositions) != 2 || !st.OpenPositions[0].PositionProfit.Equal(decimal.NewFromInt(-236000)) {
+		t.Errorf("Expected 2 open positions with first profit -236000, but got %v", st.OpenPositions)
+	}
+	if len(st.Journal) != 1 || !st.Journal[0].CashOut.Equal(decimal.NewFromInt(1000000)) {
+		t.Errorf("Expected 1 journal entry with cash out 1000000, but got %v", st.Journal)
+	}
+	if len(st.ClosedPositions) != 7 {
+		t.Errorf("Expected 7 closed positions, but got %v", len(st.ClosedPositions))
+	}
+	if len(st.Balances) != 2 || !st.Balances[1].Closing.Equal(decimal.NewFromInt(3332878)) {
+		t.Errorf("Expected 2 balances with closing 3332878, but got %v", st.Balances)
+	}
+}
+
+func TestGetClosedPositionsSummary(t *testing.T) {
+	st, _ := Parse(content)
+
+	lots := GetClosedPositions(st)
+	if len(lots) != 8 {
+		t.Errorf("Expected 8 rows include header, but got %v", len(lots))
+	}
+
+	rows := GetClosedPositionsSummary(st)
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows include header, but got %v", len(rows))
+	}
+	if rows[1][3] != "1801" || rows[1][8] != "50" || rows[1][9] != "-4800.00" {
+		t.Errorf("Expected 1801 summary qty 50 profit -4800.00, but got %v", rows[1])
+	}
+
+	// Two commodities of the same contract month are summed apart
+	st.ClosedPositions = []ClosedPosition{
+		{Market: "DCE", Product: "C", Contract: "1801", Qty: 10, CurrentProfit: decimal.NewFromInt(100), Currency: "CNY"},
+		{Market: "DCE", Product: "M", Contract: "1801", Qty: 5, CurrentProfit: decimal.NewFromInt(100), Currency: "CNY"},
+	}
+	rows = GetClosedPositionsSummary(st)
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows include header, but got %v", rows)
+	}
+	for i, expected := range [][3]string{{"C", "10", "100.00"}, {"M", "5", "100.00"}} {
+		if r := rows[i+1]; r[6] != expected[0] || r[8] != expected[1] || r[9] != expected[2] {
+			t.Errorf("Expected %s summary qty %s profit %s, but got %v", expected[0], expected[1], expected[2], r)
+		}
+	}
+}
+
+func TestCheckJournal(t *testing.T) {
+	st, _ := Parse(content)
+
+	rows := GetJournal(st)
+	if len(rows) != 2 || rows[1][3] != "1000000.00" {
+		t.Errorf("Expected 1 journal row with cash out 1000000.00, but got %v", rows)
+	}
+
+	if err := CheckJournal(st); err != nil {
+		t.Errorf("Expected journal matches Deposit/Withdrawal, but got %v", err)
+	}
+
+	st.Journal[0].CashOut = decimal.NewFromInt(900000)
+	if err := CheckJournal(st); err == nil {
+		t.Errorf("Expected journal mismatch error, but not")
+	}
+
+	// Interest is in Journal rather than Deposit/Withdrawal, of both the
+	// balances and the check
+	st.Journal = []JournalEntry{
+		{CashIn: decimal.NewFromInt(5000), Type: "Amount", Currency: "CNY"},
+		{CashIn: decimal.NewFromInt(300), Type: "Interest", Currency: "CNY"},
+	}
+	st.Balances = []Balance{{Currency: "CNY", DepositWithdrawal: decimal.NewFromInt(5000), Journal: decimal.NewFromInt(300)}}
+	if err := CheckJournal(st); err != nil {
+		t.Errorf("Expected mixed journal matches, but got %v", err)
+	}
+	if r := GetBalances(st)[1]; r[3] != "5000.00" || r[4] != "0.00" {
+		t.Errorf("Expected deposit 5000.00 withdrawal 0.00, but got %v and %v", r[3], r[4])
+	}
+
+	// A cash type which is not known is reported rather than dropped
+	st.Journal = append(st.Journal, JournalEntry{CashIn: decimal.NewFromInt(200), Type: "Transfer", Currency: "CNY"})
+	if err := CheckJournal(st); err == nil || !strings.Contains(err.Error(), "CNY: Journal 300.00, journal 500.00") {
+		t.Errorf("Expected Journal mismatch, but got %v", err)
+	}
+}
+
+func TestGetOpenLots(t *testing.T) {
+	st, _ := Parse(content)
+
+	if len(st.OpenLots) != 72 {
+		t.Errorf("Expected 72 open lots, but got %v", len(st.OpenLots))
+	}
+
+	var sale int
+	for _, l := range st.OpenLots {
+		sale += l.Sale
+	}
+	if sale != 770 {
+		t.Errorf("Expected open lots sale sum 770, but got %v", sale)
+	}
+
+	rows := GetOpenLots(st)
+	if rows[1][2] != "2017-11-20" || rows[1][4] != "14" || rows[1][14] != "2353.0000000" {
+		t.Errorf("Expected first lot opened 2017-11-20 short 14 last settlement 2353.0000000, but got %v", rows[1])
+	}
+}
+
+func TestGetBalances(t *testing.T) {
+	st, _ := Parse(content)
+
+	rows := GetBalances(st)
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows include header, but got %v", len(rows))
+	}
+	if rows[1][1] != BaseCurrency || rows[2][1] != "CNY" {
+		t.Errorf("Expected currencies %s and CNY, but got %v and %v", BaseCurrency, rows[1][1], rows[2][1])
+	}
+
+	r := rows[2]
+	if r[2] != "4337763.00" {
+		t.Errorf("Expected BalanceBf 4337763.00, but got %v", r[2])
+	}
+	if r[7] != "-4800.00" {
+		t.Errorf("Expected RealisedPL -4800.00, but got %v", r[7])
+	}
+	if r[11] != "3332878.00" {
+		t.Errorf("Expected BalanceCf 3332878.00, but got %v", r[11])
+	}
+	for _, r := range rows[1:] {
+		if r[3] != "0.00" || r[4] != "1000000.00" {
+			t.Errorf("Expected %s deposit 0.00 withdrawal 1000000.00, but got %v and %v", r[1], r[3], r[4])
+		}
+	}
+}
+
+func TestGetPosAndTrades(t *testing.T) {
+	st, _ := Parse(content)
+
+	pos := GetPos(st)
+	if r := pos[1]; r[10] != "2300.8000000" || r[11] != "2348.0000000" || r[13] != "-236,000.00" {
+		t.Errorf("Expected the prices and profit as in the bill, but got %v", r)
+	}
+
+	trades := GetTrades(st)
+	if r := trades[1]; r[10] != "1706.0000000" || r[18] != "17.00" {
+		t.Errorf("Expected the price and fee as in the bill, but got %v", r)
+	}
+}
+
+func TestGetBalancesJournal(t *testing.T) {
+	s := `|                                                                          Financial Situation
+	|Currency          |      BaseCurrency|               CNY|               USD|               HKD|
+	|Exchange          |            1.0000|            1.0000|            6.3000|            0.8000|
+	|Deposit/Withdrawal|         63,000.00|        -10,000.00|         11,500.00|          1,000.00|
+	----------------
+	`
+
+	balances, err := parseBalances(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Interest is not a cash movement, HKD splits its Deposit/Withdrawal
+	st := Statement{Balances: balances, Journal: []JournalEntry{
+		{CashIn: decimal.NewFromInt(5000), Type: "Amount", Currency: "CNY"},
+		{CashOut: decimal.NewFromInt(15000), Type: "Amount", Currency: "CNY"},
+		{CashIn: decimal.NewFromInt(300), Type: "Interest", Currency: "CNY"},
+		{CashIn: decimal.NewFromInt(12000), Type: "Amount", Currency: "USD"},
+		{CashOut: decimal.NewFromInt(500), Type: "Amount", Currency: "USD"},
+		{CashIn: decimal.NewFromInt(50), Type: "Interest", Currency: "HKD"},
+	}}
+
+	rows := GetBalances(st)
+	if len(rows) != 5 {
+		t.Fatalf("Expected 5 rows include header, but got %v", len(rows))
+	}
+	for i, expected := range [][2]string{
+		{"81400.00", "18150.00"},
+		{"5000.00", "15000.00"},
+		{"12000.00", "500.00"},
+		{"1000.00", "0.00"},
+	} {
+		r := rows[i+1]
+		if r[3] != expected[0] || r[4] != expected[1] {
+			t.Errorf("Expected %s deposit %s withdrawal %s, but got %v and %v", r[1], expected[0], expected[1], r[3], r[4])
+		}
+	}
+
+	o, _ := DefaultTemplate().Output(KindBalances)
+	o.WithdrawalSign = SignNegative
+	if r := o.Rows(st)[2]; r[3] != "5000.00" || r[4] != "-15000.00" {
+		t.Errorf("Expected negative withdrawal -15000.00, but got %v and %v", r[3], r[4])
+	}
+	if v := o.Values(st)[2]; fmt.Sprint(v[4]) != "-15000" {
+		t.Errorf("Expected negative withdrawal value -15000, but got %v", v[4])
+	}
+
+	if _, err := ParseTemplate([]byte(`{"outputs": [{"kind": "balances", "filename": "x.csv", "withdrawal_sign": "minus"}]}`)); err == nil {
+		t.Errorf("Expected unknown withdrawal sign error, but not")
+	}
+}
+
+func TestParseBalancesWithoutCurrency(t *testing.T) {
+	s := `|                                                                          Financial Situation
+	|Opening           |      4,337,763.00|
+	|Deposit/Withdrawal|     -1,000,000.00|
+	|Remark            |      see journal |
+	|Closing           |      3,332,878.00|
+	----------------
+	`
+
+	balances, err := parseBalances(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 1 {
+		t.Fatalf("Expected 1 balance, but got %v", balances)
+	}
+	b := balances[0]
+	if b.Currency != "CNY" || b.Base || !b.Opening.Equal(decimal.NewFromInt(4337763)) || !b.Closing.Equal(decimal.NewFromInt(3332878)) {
+		t.Errorf("Expected CNY balance opening 4337763 closing 3332878, but got %v", b)
+	}
+
+	rows := GetBalances(Statement{Balances: balances})
+	if len(rows) != 2 || rows[1][1] != "CNY" || rows[1][4] != "1000000.00" {
+		t.Errorf("Expected a CNY row with withdrawal 1000000.00, but got %v", rows)
+	}
+}
+
+func TestParseBalancesMultiCurrency(t *testing.T) {
+	s := `|                                                                          Financial Situation                                                                           
+	|Currency          |      BaseCurrency|               CNY|               USD|                  |
+	|Exchange          |            1.0000|            1.0000|            6.3000|                  |
+	|Opening           |        163,000.00|        100,000.00|         10,000.00|                  |
+	|Closing           |        169,300.00|        100,000.00|         11,000.00|                  |
+	----------------
+	`
+
+	balances, err := parseBalances(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 3 {
+		t.Fatalf("Expected 3 balances, but got %v", len(balances))
+	}
+
+	if !balances[0].Base || balances[0].Currency != BaseCurrency {
+		t.Errorf("Expected first balance is base currency, but got %v", balances[0])
+	}
+
+	usd := balances[2]
+	if usd.Currency != "USD" || !usd.ExchangeRate.Equal(decimal.RequireFromString("6.3")) || !usd.Opening.Equal(decimal.NewFromInt(10000)) || !usd.Closing.Equal(decimal.NewFromInt(11000)) {
+		t.Errorf("Expected USD balance rate 6.3 opening 10000 closing 11000, but got %v", usd)
+	}
+
+	// The default layout keeps the WANDA columns, a template adds the rate
+	o, _ := DefaultTemplate().Output(KindBalances)
+	if n := len(o.Header()); n != 17 {
+		t.Errorf("Expected 17 WANDA balances columns, but got %v", n)
+	}
+	o.Columns = append(o.Columns[:len(o.Columns):len(o.Columns)], Column{Header: "ExchangeRate", Field: "exchange_rate", Format: "%.4f"})
+	if r := o.Rows(Statement{Balances: balances})[3]; r[17] != "6.3000" {
+		t.Errorf("Expected USD exchange rate 6.3000, but got %v", r)
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	for s, expected := range map[string]string{
+		"98,765,432,109,876.54":  "98765432109876.54",
+		"-12,345,678,901,234.56": "-12345678901234.56",
+		"-0.10":                  "-0.10",
+		"0.29":                   "0.29",
+		"":                       "0.00",
+	} {
+		d, err := parseAmount(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if a := formatAmount(d); a != expected {
+			t.Errorf("%q: expected %s, but got %s", s, expected, a)
+		}
+	}
+
+	if _, err := parseAmount("1,000.0x"); err == nil {
+		t.Errorf("Expected invalid number error, but not")
+	}
+}
+
+func TestGetBalancesLarge(t *testing.T) {
+	s := `|                                                                          Financial Situation
+	|Currency          |      BaseCurrency|               CNY|
+	|Exchange          |            1.0000|            1.0000|
+	|Opening           | 98,765,432,109,876.54| 98,765,432,109,876.54|
+	|Deposit/Withdrawal|-12,345,678,901,234.56|-12,345,678,901,234.56|
+	|Closing           | 86,419,753,208,642.01| 86,419,753,208,642.01|
+	----------------
+	`
+
+	balances, err := parseBalances(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := GetBalances(Statement{Balances: balances})
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows include header, but got %v", len(rows))
+	}
+
+	r := rows[2]
+	if r[2] != "98765432109876.54" || r[4] != "12345678901234.56" || r[11] != "86419753208642.01" {
+		t.Errorf("Expected exact large amounts, but got %v", r)
+	}
+
+	b := balances[1]
+	if !b.Opening.Add(b.DepositWithdrawal).Sub(b.Closing).Equal(decimal.RequireFromString("-0.03")) {
+		t.Errorf("Expected exact difference -0.03, but got %v", b.Opening.Add(b.DepositWithdrawal).Sub(b.Closing))
+	}
+}
+
+func TestGetTradesOption(t *testing.T) {
+	s := `|                                                                           Trade Confirmation                                                                           
+	|  Date    | Market |        Product         |    Contract    | Open/Close  | FocusClose  |Buy/Sale|MatchQty| Match Price  |    Premium     |     Fee      |Currency|Remarks |         Time         |
+	|2018-03-01|  DCE   |           m            |  1805-C-2800   |    Open     |             |  Buy   |   2    |    85.5000000|        -1710.00|          3.00|  CNY   |        | 2018-03-01 09:30:15  |
+	|2018-03-01|  ZCE   |           SR           |   SR805P6000   |    Open     |             |  Sale  |   1    |    90.0000000|          900.00|          1.50|  CNY   |        | 2018-03-01 09:31:15  |
+	| Summary  |        |                        |                |             |             |        |   3    |              |         -810.00|          4.50|  CNY   |        |
+	----------------
+	`
+
+	trades, err := parseTrades(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := Statement{Trades: trades}
+	rows := GetTrades(st)
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows include header, but got %v", len(rows))
+	}
+
+	r := rows[1]
+	if r[ColFutOpt] != Option || r[ColStrikePrice] != "2800" || r[ColSubType] != "C" || r[ColContractMonth] != "5" || r[ColContractYear] != "2018" {
+		t.Errorf("Expected m1805 call option strike 2800, but got %v", r)
+	}
+	if st.Trades[0].Underlying != "m1805" || !st.Trades[0].Premium.Equal(decimal.NewFromInt(-1710)) {
+		t.Errorf("Expected underlying m1805 premium -1710, but got %v", st.Trades[0])
+	}
+
+	r = rows[2]
+	if r[ColFutOpt] != Option || r[ColStrikePrice] != "6000" || r[ColSubType] != "P" {
+		t.Errorf("Expected SR805 put option strike 6000, but got %v", r)
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	b := []byte(`{
+		"name": "other",
+		"outputs": [
+			{
+				"kind": "trades",
+				"name": "Trades",
+				"filename": "{account}_OTHER_Trades_{statement_date}.csv",
+				"columns": [
+					{"header": "Account", "field": "account"},
+					{"header": "Date", "field": "trade_date", "format": "02/01/2006"},
+					{"header": "Qty", "field": "qty"},
+					{"header": "Price", "field": "price", "format": "%.2f"},
+					{"header": "Office", "value": "Beijing"}
+				]
+			}
+		]
+	}`)
+
+	tpl, err := ParseTemplate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, _ := Parse(content)
+	o, ok := tpl.Output(KindTrades)
+	if !ok {
+		t.Fatalf("Expected template has trades output, but not")
+	}
+
+	rows := o.Rows(st)
+	if len(rows) != 8 {
+		t.Fatalf("Expected 8 rows include header, but got %v", len(rows))
+	}
+	if r := rows[1]; r[1] != "12/12/2017" || r[2] != "10" || r[3] != "1706.00" || r[4] != "Beijing" {
+		t.Errorf("Expected row rendered by template, but got %v", r)
+	}
+
+	values := o.Values(st)
+	v := values[1]
+	if d, _ := v[3].(decimal.Decimal); v[0] != "61188805" || v[2] != 10 || !d.Equal(decimal.NewFromInt(1706)) || v[4] != "Beijing" {
+		t.Errorf("Expected typed row, but got %#v", v)
+	}
+	if d, ok := values[1][1].(time.Time); !ok || d.Format("2006-01-02") != "2017-12-12" {
+		t.Errorf("Expected trade date 2017-12-12, but got %#v", values[1][1])
+	}
+
+	if name := o.FileName(st.Header, time.Now()); name != "61188805_OTHER_Trades_20171212.csv" {
+		t.Errorf("Expected file name 61188805_OTHER_Trades_20171212.csv, but got %v", name)
+	}
+
+	if _, err := ParseTemplate([]byte(`{"outputs": [{"kind": "trades", "filename": "x.csv", "columns": [{"header": "X", "field": "nope"}]}]}`)); err == nil {
+		t.Errorf("Expected unknown field error, but not")
+	}
+
+	for _, o := range []string{
+		`{"kind": "trades", "name": "Trades of the account in the month", "filename": "x.csv"}`,
+		`{"kind": "trades", "name": "Trades/Fees", "filename": "x.csv"}`,
+		`{"kind": "trades", "name": "Trades", "filename": "x.csv"}, {"kind": "positions", "name": "trades", "filename": "y.csv"}`,
+	} {
+		if _, err := ParseTemplate([]byte(`{"outputs": [` + o + `]}`)); err == nil {
+			t.Errorf("%s: expected invalid name error, but not", o)
+		}
+	}
+	if tpl, err := ParseTemplate([]byte(`{"outputs": [{"kind": "trades", "filename": "x.csv"}]}`)); err != nil || tpl.Outputs[0].Name != KindTrades {
+		t.Errorf("Expected name defaults to the kind, but got %v %v", tpl, err)
+	}
+
+	for _, c := range []string{
+		`{"header": "X", "field": "commission", "format": "01/02/2006"}`,
+		`{"header": "X", "field": "price", "format": "%d"}`,
+		`{"header": "X", "field": "trade_date", "format": "%.2f"}`,
+		`{"header": "X", "field": "trade_date", "format": "yyyy-mm-dd"}`,
+		`{"header": "X", "field": "qty", "format": "%05d"}`,
+	} {
+		if _, err := ParseTemplate([]byte(`{"outputs": [{"kind": "trades", "filename": "x.csv", "columns": [` + c + `]}]}`)); err == nil {
+			t.Errorf("%s: expected invalid format error, but not", c)
+		}
+	}
+}
+
+func TestFieldType(t *testing.T) {
+	st, _ := Parse(content)
+	for _, kind := range Kinds() {
+		for _, r := range Records(kind, st) {
+			for _, f := range Fields(kind) {
+				var ok bool
+				switch v := r[f].(type) {
+				case nil:
+					ok = true
+				case string:
+					ok = FieldType(f) == TypeString
+				case int:
+					ok = FieldType(f) == TypeInt
+				case bool:
+					ok = FieldType(f) == TypeBool
+				case amount:
+					ok = FieldType(f) == TypeAmount
+				case price:
+					ok = FieldType(f) == TypePrice
+				case time.Time:
+					ok = FieldType(f) == TypeDate || FieldType(f) == TypeTime
+				default:
+					t.Errorf("%s: %s: unexpected value %#v", kind, f, v)
+				}
+				if !ok {
+					t.Errorf("%s: %s: value %#v is not %s", kind, f, r[f], FieldType(f))
+				}
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	st, err := Parse(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.TradeSummaries) != 1 || st.TradeSummaries[0].MatchQty != 50 || len(st.PositionSummaries) != 1 {
+		t.Fatalf("Expected trade and position summaries, but got %v and %v", st.TradeSummaries, st.PositionSummaries)
+	}
+	if d := Validate(st); len(d) != 0 {
+		t.Errorf("Expected consistent bill, but got %v", d)
+	}
+
+	st.Trades[0].Fee = st.Trades[0].Fee.Add(decimal.NewFromInt(1))
+	st.OpenLots[0].Sale++
+	st.Balances[1].Floating = decimal.NewFromInt(-230000)
+	checks := []string{}
+	for _, d := range Validate(st) {
+		if d.Account != "61188805" {
+			t.Errorf("Expected discrepancy of account 61188805, but got %v", d)
+		}
+		checks = append(checks, d.Check+" "+d.Item+" "+d.Field)
+	}
+	expected := []string{
+		"equity CNY Equity",
+		"trades CNY Fee",
+		"positions ZCE RM 805 CNY Sale",
+		"floating CNY Floating",
+	}
+	if strings.Join(checks, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("Expected discrepancies %v, but got %v", expected, checks)
+	}
+
+	// A bill with only the gathered positions skips CheckPositions
+	st.OpenLots = nil
+	for _, d := range Validate(st) {
+		if d.Check == CheckPositions {
+			t.Errorf("Expected no positions check without open lots, but got %v", d)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	s := strings.Replace(content, "|2017-12-12|  DCE   |           C            |      1801      |    Close    |             |  Buy   |   3    |", "|2017-12-12|  DCE   |           C            |      1801      |    Close    |             |  Buy   |   3x   |", 1)
+
+	_, err := Parse(s)
+	pe, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("Expected *ParseError, but got %v", err)
+	}
+	if pe.Segment != "Trade Confirmation" || pe.Line != 17 || pe.Column != 8 || pe.ColumnName != "MatchQty" || pe.Text != "3x" {
+		t.Errorf("Expected error at line 17 column 8 MatchQty of Trade Confirmation, but got %v", pe)
+	}
+
+	s = strings.Replace(content, "|  1704.0000000|            0.00|         17.00|  CNY   |        | 2017-12-12 11:06:14  |", "|  1704.0000000|", 1)
+	if _, err = Parse(s); err == nil {
+		t.Fatalf("Expected missing column error, but not")
+	}
+	if pe, ok = err.(*ParseError); !ok || pe.Line != 16 || pe.Column != 10 {
+		t.Errorf("Expected missing column 10 error at line 16, but got %v", err)
+	}
+}
@@ -0,0 +1,455 @@
+package converter
+
+import (
+	"sort"
+
+	"github.com/fengdu/billconverter/util"
+	"github.com/shopspring/decimal"
+)
+
+// Output kinds of a statement
+const (
+	KindBalances          = "balances"
+	KindPositions         = "positions"
+	KindOpenLots          = "openlots"
+	KindTrades            = "trades"
+	KindJournal           = "journal"
+	KindRealisedPL        = "realisedpl"
+	KindRealisedPLSummary = "realisedplsummary"
+)
+
+// Record a row of an output kind, keyed by field name. Values are string,
+// int, bool, time.Time, amount, price or nil.
+type Record map[string]interface{}
+
+// Field value types of FieldType
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeBool   = "bool"
+	// TypeAmount money value, decimal.Decimal in Values
+	TypeAmount = "amount"
+	// TypePrice price value, decimal.Decimal in Values
+	TypePrice = "price"
+	// TypeDate time.Time of a day
+	TypeDate = "date"
+	// TypeTime time.Time with the time of day
+	TypeTime = "time"
+)
+
+// fieldTypes types of the fields which are not string, a field has the same
+// type in every kind
+var fieldTypes = map[string]string{
+	"base":                TypeBool,
+	"long":                TypeInt,
+	"short":               TypeInt,
+	"qty":                 TypeInt,
+	"exchange_rate":       TypePrice,
+	"price":               TypePrice,
+	"sett_price":          TypePrice,
+	"last_sett_price":     TypePrice,
+	"open_price":          TypePrice,
+	"close_price":         TypePrice,
+	"strike_price":        TypePrice,
+	"statement_date":      TypeDate,
+	"bill_date":           TypeDate,
+	"trade_date":          TypeDate,
+	"open_date":           TypeDate,
+	"date":                TypeDate,
+	"trade_time":          TypeTime,
+	"balance_bf":          TypeAmount,
+	"balance_cf":          TypeAmount,
+	"deposit":             TypeAmount,
+	"withdrawal":          TypeAmount,
+	"deposit_withdrawal":  TypeAmount,
+	"journal":             TypeAmount,
+	"option_premium":      TypeAmount,
+	"delivery_proceed":    TypeAmount,
+	"realised_pl":         TypeAmount,
+	"commission":          TypeAmount,
+	"interest":            TypeAmount,
+	"unrealised_pl":       TypeAmount,
+	"floating":            TypeAmount,
+	"equity":              TypeAmount,
+	"pre_equity":          TypeAmount,
+	"option_market_value": TypeAmount,
+	"initial_margin":      TypeAmount,
+	"maintenance_margin":  TypeAmount,
+	"excess":              TypeAmount,
+	"margin":              TypeAmount,
+	"premium":             TypeAmount,
+	"cash_in":             TypeAmount,
+	"cash_out":            TypeAmount,
+}
+
+// FieldType value type of a field
+func FieldType(field string) string {
+	if t, ok := fieldTypes[field]; ok {
+		return t
+	}
+	return TypeString
+}
+
+// amount money value, written with 2 decimals
+type amount decimal.Decimal
+
+// price price value, written with the shortest decimals
+type price decimal.Decimal
+
+type recordSet struct {
+	fields []string
+	build  func(st Statement) []Record
+}
+
+var recordSets = map[string]recordSet{
+	KindBalances: {
+		fields: []string{
+			"account", "currency", "base", "exchange_rate", "balance_bf",
+			"deposit", "withdrawal", "deposit_withdrawal", "journal", "option_premium",
+			"delivery_proceed", "realised_pl", "commission", "interest", "balance_cf",
+			"unrealised_pl", "floating", "equity", "pre_equity", "option_market_value",
+			"initial_margin", "maintenance_margin", "excess", "bill_date", "statement_date",
+		},
+		build: balanceRecords,
+	},
+	KindPositions: {
+		fields: append([]string{
+			"account", "statement_date", "long", "short", "exchange",
+			"contract", "commodity", "price", "sett_price", "currency",
+			"unrealised_pl", "option_market_value", "margin",
+		}, instrumentFields...),
+		build: positionRecords,
+	},
+	KindOpenLots: {
+		fields: append([]string{
+			"account", "statement_date", "open_date", "long", "short",
+			"exchange", "contract", "commodity", "open_price", "last_sett_price",
+			"sett_price", "currency", "unrealised_pl", "option_market_value",
+		}, instrumentFields...),
+		build: openLotRecords,
+	},
+	KindTrades: {
+		fields: append([]string{
+			"account", "statement_date", "trade_date", "trade_time", "long",
+			"short", "exchange", "contract", "commodity", "open_close",
+			"buy_sell", "qty", "price", "premium", "commission",
+			"currency", "remarks",
+		}, instrumentFields...),
+		build: tradeRecords,
+	},
+	KindJournal: {
+		fields: []string{
+			"account", "statement_date", "date", "cash_in", "cash_out",
+			"type", "currency", "remarks",
+		},
+		build: journalRecords,
+	},
+	KindRealisedPL: {
+		fields: []string{
+			"account", "statement_date", "open_date", "exchange", "contract",
+			"contract_month", "contract_year", "commodity", "buy_sell", "qty",
+			"open_price", "close_price", "sett_price", "currency", "realised_pl",
+		},
+		build: realisedPLRecords,
+	},
+	KindRealisedPLSummary: {
+		fields: []string{
+			"account", "statement_date", "exchange", "contract", "contract_month",
+			"contract_year", "commodity", "currency", "qty", "realised_pl",
+		},
+		build: realisedPLSummaryRecords,
+	},
+}
+
+var instrumentFields = []string{
+	"fut_opt", "underlying", "expiry", "contract_month", "contract_year",
+	"strike_price", "sub_type",
+}
+
+// Kinds list all output kinds
+func Kinds() []string {
+	result := []string{}
+	for k := range recordSets {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// Fields list the fields of an output kind
+func Fields(kind string) []string {
+	return recordSets[kind].fields
+}
+
+// Records build the records of an output kind from statement
+func Records(kind string, st Statement) []Record {
+	set, ok := recordSets[kind]
+	if !ok {
+		return nil
+	}
+	return set.build(st)
+}
+
+// Values values of the fields in order, amounts and prices are decimal.Decimal
+// and a missing field is nil
+func (r Record) Values(fields []string) []interface{} {
+	result := []interface{}{}
+	for _, f := range fields {
+		switch v := r[f].(type) {
+		case amount:
+			result = append(result, decimal.Decimal(v))
+		case price:
+			result = append(result, decimal.Decimal(v))
+		default:
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func headerRecord(bill BillBaseInfo) Record {
+	return Record{
+		"account":        bill.AccountNo,
+		"statement_date": bill.StatementDateEnd,
+	}
+}
+
+func (r Record) setInstrument(i Instrument) {
+	contractMonth, contractYear, _ := util.ParseMonthAndYear(i.Expiry)
+	r["fut_opt"] = i.FutOpt
+	r["underlying"] = i.Underlying
+	r["expiry"] = i.Expiry
+	r["contract_month"] = contractMonth
+	r["contract_year"] = contractYear
+	r["sub_type"] = i.PutCall
+	if i.FutOpt == Option {
+		r["strike_price"] = price(i.StrikePrice)
+	}
+}
+
+// cashFlows deposit and withdrawal of a balance as positive totals of the
+// Journal Description cash movements of its currency, a currency without them
+// splits the netted Deposit/Withdrawal. The base currency sums the cash flows
+// of the other currencies at their exchange rate.
+func cashFlows(st Statement, b Balance) (deposit, withdrawal decimal.Decimal) {
+	var found bool
+	if b.Base {
+		for _, o := range st.Balances {
+			if o.Base {
+				continue
+			}
+			found = true
+			d, w := cashFlows(st, o)
+			deposit = deposit.Add(d.Mul(o.ExchangeRate))
+			withdrawal = withdrawal.Add(w.Mul(o.ExchangeRate))
+		}
+	} else {
+		for _, j := range st.Journal {
+			if j.Currency != b.Currency || !j.Cash() {
+				continue
+			}
+			found = true
+			deposit = deposit.Add(j.CashIn)
+			withdrawal = withdrawal.Add(j.CashOut)
+		}
+	}
+	if found {
+		return deposit, withdrawal
+	}
+
+	if b.DepositWithdrawal.IsPositive() {
+		return b.DepositWithdrawal, decimal.Zero
+	}
+	return decimal.Zero, b.DepositWithdrawal.Neg()
+}
+
+func balanceRecords(st Statement) []Record {
+	result := []Record{}
+	for _, b := range st.Balances {
+		r := headerRecord(st.Header)
+		r["bill_date"] = st.Header.BillDate
+		r["currency"] = b.Currency
+		r["base"] = b.Base
+		r["exchange_rate"] = price(b.ExchangeRate)
+		r["balance_bf"] = amount(b.Opening)
+		r["deposit_withdrawal"] = amount(b.DepositWithdrawal)
+		deposit, withdrawal := cashFlows(st, b)
+		r["deposit"] = amount(deposit)
+		r["withdrawal"] = amount(withdrawal)
+		r["journal"] = amount(b.Journal)
+		r["option_premium"] = amount(b.OptionPremium)
+		r["delivery_proceed"] = amount(b.Delivery)
+		r["realised_pl"] = amount(b.Trading)
+		r["commission"] = amount(b.Commissions)
+		r["interest"] = amount(b.Journal)
+		r["balance_cf"] = amount(b.Closing)
+		r["unrealised_pl"] = amount(b.Unrealized)
+		r["floating"] = amount(b.Floating)
+		r["equity"] = amount(b.Equity)
+		r["pre_equity"] = amount(b.PreEquity)
+		r["option_market_value"] = amount(b.OptionMarketValue)
+		r["initial_margin"] = amount(b.InitialMargin)
+		r["maintenance_margin"] = amount(b.MaintenanceMargin)
+		r["excess"] = amount(b.Excess)
+		result = append(result, r)
+	}
+
+	return result
+}
+
+func positionRecords(st Statement) []Record {
+	result := []Record{}
+	for _, p := range st.OpenPositions {
+		r := headerRecord(st.Header)
+		r.setInstrument(p.Instrument)
+		r["long"] = p.Buy
+		r["short"] = p.Sale
+		r["exchange"] = p.Market
+		r["contract"] = p.Contract
+		r["commodity"] = p.Product
+		r["price"] = price(p.MatchPrice)
+		r["sett_price"] = price(p.SettlementPrice)
+		r["currency"] = p.Currency
+		r["unrealised_pl"] = amount(p.PositionProfit)
+		r["option_market_value"] = amount(p.OptionMarketValue)
+		r["margin"] = amount(p.Margin)
+		result = append(result, r)
+	}
+
+	return result
+}
+
+func openLotRecords(st Statement) []Record {
+	result := []Record{}
+	for _, l := range st.OpenLots {
+		r := headerRecord(st.Header)
+		r.setInstrument(l.Instrument)
+		r["open_date"] = l.Date
+		r["long"] = l.Buy
+		r["short"] = l.Sale
+		r["exchange"] = l.Market
+		r["contract"] = l.Contract
+		r["commodity"] = l.Product
+		r["open_price"] = price(l.MatchPrice)
+		r["last_sett_price"] = price(l.LastSettlement)
+		r["sett_price"] = price(l.SettlementPrice)
+		r["currency"] = l.Currency
+		r["unrealised_pl"] = amount(l.CurrentProfit)
+		r["option_market_value"] = amount(l.OptionMarketValue)
+		result = append(result, r)
+	}
+
+	return result
+}
+
+func tradeRecords(st Statement) []Record {
+	result := []Record{}
+	for _, t := range st.Trades {
+		r := headerRecord(st.Header)
+		r.setInstrument(t.Instrument)
+
+		long, short := 0, 0
+		if t.BuySale == "Sale" {
+			short = t.MatchQty
+		} else if t.BuySale == "Buy" {
+			long = t.MatchQty
+		}
+
+		r["trade_date"] = t.Date
+		r["trade_time"] = t.Time
+		r["long"] = long
+		r["short"] = short
+		r["exchange"] = t.Market
+		r["contract"] = t.Contract
+		r["commodity"] = t.Product
+		r["open_close"] = t.OpenClose
+		r["buy_sell"] = t.BuySale
+		r["qty"] = t.MatchQty
+		r["price"] = price(t.MatchPrice)
+		r["premium"] = amount(t.Premium)
+		r["commission"] = amount(t.Fee)
+		r["currency"] = t.Currency
+		r["remarks"] = t.Remarks
+		result = append(result, r)
+	}
+
+	return result
+}
+
+func journalRecords(st Statement) []Record {
+	result := []Record{}
+	for _, j := range st.Journal {
+		r := headerRecord(st.Header)
+		r["date"] = j.Date
+		r["cash_in"] = amount(j.CashIn)
+		r["cash_out"] = amount(j.CashOut)
+		r["type"] = j.Type
+		r["currency"] = j.Currency
+		r["remarks"] = j.Remarks
+		result = append(result, r)
+	}
+
+	return result
+}
+
+func realisedPLRecords(st Statement) []Record {
+	result := []Record{}
+	for _, c := range st.ClosedPositions {
+		contractMonth, contractYear, _ := util.ParseMonthAndYear(c.Contract)
+
+		r := headerRecord(st.Header)
+		r["open_date"] = c.Date
+		r["exchange"] = c.Market
+		r["contract"] = c.Contract
+		r["contract_month"] = contractMonth
+		r["contract_year"] = contractYear
+		r["commodity"] = c.Product
+		r["buy_sell"] = c.BuySale
+		r["qty"] = c.Qty
+		r["open_price"] = price(c.OpenPrice)
+		r["close_price"] = price(c.ClosePrice)
+		r["sett_price"] = price(c.SettlePrice)
+		r["currency"] = c.Currency
+		r["realised_pl"] = amount(c.CurrentProfit)
+		result = append(result, r)
+	}
+
+	return result
+}
+
+// realisedPLSummaryRecords sum the realised profit of Close Positions by contract
+func realisedPLSummaryRecords(st Statement) []Record {
+	keys := []string{}
+	set := make(map[string]*ClosedPosition)
+	for _, c := range st.ClosedPositions {
+		key := c.Market + "|" + c.Product + "|" + c.Contract + "|" + c.Currency
+		s, ok := set[key]
+		if !ok {
+			s = &ClosedPosition{Market: c.Market, Product: c.Product, Contract: c.Contract, Currency: c.Currency}
+			set[key] = s
+			keys = append(keys, key)
+		}
+		s.Qty += c.Qty
+		s.CurrentProfit = s.CurrentProfit.Add(c.CurrentProfit)
+	}
+
+	result := []Record{}
+	for _, key := range keys {
+		s := set[key]
+		contractMonth, contractYear, _ := util.ParseMonthAndYear(s.Contract)
+
+		r := headerRecord(st.Header)
+		r["exchange"] = s.Market
+		r["contract"] = s.Contract
+		r["contract_month"] = contractMonth
+		r["contract_year"] = contractYear
+		r["commodity"] = s.Product
+		r["currency"] = s.Currency
+		r["qty"] = s.Qty
+		r["realised_pl"] = amount(s.CurrentProfit)
+		result = append(result, r)
+	}
+
+	return result
+}
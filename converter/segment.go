@@ -0,0 +1,224 @@
+package converter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fengdu/billconverter/util"
+	"github.com/shopspring/decimal"
+)
+
+// ParseError error of parsing a bill, with the position of the offending text
+type ParseError struct {
+	File    string
+	Segment string
+	// Line 1-based line number in the bill, 0 if the segment is not found
+	Line int
+	// Column 1-based column of the table, 0 if the error is not about a cell
+	Column     int
+	ColumnName string
+	Text       string
+	Msg        string
+}
+
+func (e *ParseError) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		b.WriteString(e.File + ":")
+	}
+	fmt.Fprintf(&b, "%d: [%s]", e.Line, e.Segment)
+	if e.Column > 0 {
+		fmt.Fprintf(&b, " column %d", e.Column)
+		if e.ColumnName != "" {
+			fmt.Fprintf(&b, " (%s)", e.ColumnName)
+		}
+	}
+	fmt.Fprintf(&b, ": %s", e.Msg)
+	if e.Text != "" {
+		fmt.Fprintf(&b, ": %q", e.Text)
+	}
+
+	return b.String()
+}
+
+// segment table style segment of the bill
+type segment struct {
+	title string
+	// line of the title, 0 if not found
+	line int
+	rows []row
+}
+
+// row cells of a table line
+type row struct {
+	line  int
+	cells []string
+}
+
+// readSegment parse table style segment to rows
+func readSegment(content, title string) segment {
+	result := segment{title: title}
+	s := util.ParseSegment(content, title)
+	if s == "" {
+		return result
+	}
+
+	result.line = lineOf(content, s)
+	for i, l := range strings.Split(s, "\n") {
+		line := strings.Trim(strings.TrimSpace(l), "|")
+		if !strings.Contains(line, "|") {
+			continue
+		}
+		result.rows = append(result.rows, row{line: result.line + i, cells: strings.Split(line, "|")})
+	}
+
+	return result
+}
+
+// lineOf line number of the first line of s in content
+func lineOf(content, s string) int {
+	i := strings.Index(content, s)
+	if i < 0 {
+		return 0
+	}
+	return strings.Count(content[:i], "\n") + 1
+}
+
+// records the data rows, skip the column title and Summary rows
+func (s segment) records() []row {
+	result := []row{}
+	if len(s.rows) < 2 {
+		return result
+	}
+
+	for _, r := range s.rows[1:] {
+		if strings.TrimSpace(r.cells[0]) == "Summary" {
+			continue
+		}
+		result = append(result, r)
+	}
+
+	return result
+}
+
+// summaries the Summary rows, one per currency
+func (s segment) summaries() []row {
+	result := []row{}
+	for _, r := range s.rows {
+		if strings.TrimSpace(r.cells[0]) == "Summary" {
+			result = append(result, r)
+		}
+	}
+
+	return result
+}
+
+func (s segment) columnName(i int) string {
+	if len(s.rows) == 0 || i >= len(s.rows[0].cells) {
+		return ""
+	}
+	return strings.TrimSpace(s.rows[0].cells[i])
+}
+
+func (s segment) errorf(r row, i int, text, format string, args ...interface{}) *ParseError {
+	e := &ParseError{
+		Segment: s.title,
+		Line:    r.line,
+		Text:    strings.TrimSpace(text),
+		Msg:     fmt.Sprintf(format, args...),
+	}
+	if i >= 0 {
+		e.Column = i + 1
+		e.ColumnName = s.columnName(i)
+	}
+
+	return e
+}
+
+// cellReader read typed cells of a row, keeps the first error
+type cellReader struct {
+	seg segment
+	row row
+	err error
+}
+
+func (s segment) reader(r row) *cellReader {
+	return &cellReader{seg: s, row: r}
+}
+
+func (c *cellReader) fail(i int, text, format string, args ...interface{}) {
+	if c.err == nil {
+		c.err = c.seg.errorf(c.row, i, text, format, args...)
+	}
+}
+
+// text required cell
+func (c *cellReader) text(i int) string {
+	if i >= len(c.row.cells) {
+		c.fail(i, strings.Join(c.row.cells, "|"), "missing column, got %d columns", len(c.row.cells))
+		return ""
+	}
+	return strings.TrimSpace(c.row.cells[i])
+}
+
+// optional cell, blank if the row is short
+func (c *cellReader) optional(i int) string {
+	if i >= len(c.row.cells) {
+		return ""
+	}
+	return strings.TrimSpace(c.row.cells[i])
+}
+
+// amount number like "1,000,000.00", blank is zero
+func (c *cellReader) amount(i int) decimal.Decimal {
+	s := c.text(i)
+	d, err := parseAmount(s)
+	if err != nil {
+		c.fail(i, s, "invalid number")
+	}
+	return d
+}
+
+func (c *cellReader) qty(i int) int {
+	s := c.text(i)
+	if s == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		c.fail(i, s, "invalid quantity")
+	}
+	return n
+}
+
+// date date like "2017-12-12", blank is zero time
+func (c *cellReader) date(i int) time.Time {
+	return c.timeOf(i, c.text(i), "2006-01-02")
+}
+
+// optionalTime optional cell of layout
+func (c *cellReader) optionalTime(i int, layout string) time.Time {
+	return c.timeOf(i, c.optional(i), layout)
+}
+
+func (c *cellReader) timeOf(i int, s, layout string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(layout, s)
+	if err != nil {
+		c.fail(i, s, "invalid date, expect %s", layout)
+	}
+	return t
+}
+
+// parseAmount parse number like "1,000,000.00" exactly, blank is zero
+func parseAmount(s string) (decimal.Decimal, error) {
+	s = strings.Replace(strings.TrimSpace(s), ",", "", -1)
+	if s == "" {
+		return decimal.Zero, nil
+	}
+	return decimal.NewFromString(s)
+}
@@ -0,0 +1,520 @@
+package converter
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fengdu/billconverter/util"
+	"github.com/shopspring/decimal"
+)
+
+// Statement typed model of a whole bill
+type Statement struct {
+	Header          BillBaseInfo
+	Trades          []Trade
+	OpenPositions   []OpenPosition
+	OpenLots        []OpenLot
+	Balances        []Balance
+	Journal         []JournalEntry
+	ClosedPositions []ClosedPosition
+	// TradeSummaries Summary rows of Trade Confirmation
+	TradeSummaries []TradeSummary
+	// PositionSummaries Summary rows of Gathered Open Positions
+	PositionSummaries []PositionSummary
+}
+
+// Futures and options of Instrument.FutOpt
+const (
+	Future = "F"
+	Option = "O"
+)
+
+// Instrument futures or option decoded from the contract code
+type Instrument struct {
+	FutOpt      string
+	Underlying  string
+	Expiry      string
+	StrikePrice decimal.Decimal
+	PutCall     string
+}
+
+// Trade a row of Trade Confirmation segment
+type Trade struct {
+	Instrument
+	Date       time.Time
+	Market     string
+	Product    string
+	Contract   string
+	OpenClose  string
+	FocusClose string
+	BuySale    string
+	MatchQty   int
+	MatchPrice decimal.Decimal
+	Premium    decimal.Decimal
+	Fee        decimal.Decimal
+	Currency   string
+	Remarks    string
+	Time       time.Time
+}
+
+// OpenPosition a row of Gathered Open Positions segment
+type OpenPosition struct {
+	Instrument
+	Market            string
+	Product           string
+	Contract          string
+	Buy               int
+	Sale              int
+	MatchPrice        decimal.Decimal
+	SettlementPrice   decimal.Decimal
+	PositionProfit    decimal.Decimal
+	OptionMarketValue decimal.Decimal
+	Margin            decimal.Decimal
+	Currency          string
+}
+
+// OpenLot a row of Detailed Open Positions segment
+type OpenLot struct {
+	Instrument
+	Date              time.Time
+	Market            string
+	Product           string
+	Contract          string
+	Buy               int
+	Sale              int
+	MatchPrice        decimal.Decimal
+	LastSettlement    decimal.Decimal
+	SettlementPrice   decimal.Decimal
+	CurrentProfit     decimal.Decimal
+	OptionMarketValue decimal.Decimal
+	Currency          string
+}
+
+// JournalEntry a row of Journal Description segment
+type JournalEntry struct {
+	Date     time.Time
+	CashIn   decimal.Decimal
+	CashOut  decimal.Decimal
+	Type     string
+	Currency string
+	Remarks  string
+}
+
+// cashJournalTypes Journal Description types of the deposits and withdrawals,
+// which sum to Deposit/Withdrawal of Financial Situation. The other types such
+// as interest sum to its Journal.
+var cashJournalTypes = map[string]bool{"Amount": true}
+
+// Cash whether the entry is a deposit or withdrawal
+func (j JournalEntry) Cash() bool {
+	return cashJournalTypes[j.Type]
+}
+
+// ClosedPosition a row of Close Positions segment
+type ClosedPosition struct {
+	Date          time.Time
+	Market        string
+	Product       string
+	Contract      string
+	BuySale       string
+	Qty           int
+	OpenPrice     decimal.Decimal
+	ClosePrice    decimal.Decimal
+	SettlePrice   decimal.Decimal
+	CurrentProfit decimal.Decimal
+	Currency      string
+}
+
+// TradeSummary a Summary row of Trade Confirmation, the totals of a currency
+type TradeSummary struct {
+	MatchQty int
+	Premium  decimal.Decimal
+	Fee      decimal.Decimal
+	Currency string
+}
+
+// PositionSummary a Summary row of Gathered Open Positions, the totals of a currency
+type PositionSummary struct {
+	Buy               int
+	Sale              int
+	PositionProfit    decimal.Decimal
+	OptionMarketValue decimal.Decimal
+	Margin            decimal.Decimal
+	Currency          string
+}
+
+// BaseCurrency currency of the Balance which sums all currencies in base currency
+const BaseCurrency = "BASE"
+
+// Balance a currency column of Financial Situation segment
+type Balance struct {
+	Currency          string
+	Base              bool
+	ExchangeRate      decimal.Decimal
+	Opening           decimal.Decimal
+	DepositWithdrawal decimal.Decimal
+	Journal           decimal.Decimal
+	Commissions       decimal.Decimal
+	Trading           decimal.Decimal
+	Delivery          decimal.Decimal
+	OptionPremium     decimal.Decimal
+	Closing           decimal.Decimal
+	Unrealized        decimal.Decimal
+	Floating          decimal.Decimal
+	Equity            decimal.Decimal
+	PreEquity         decimal.Decimal
+	OptionMarketValue decimal.Decimal
+	InitialMargin     decimal.Decimal
+	MaintenanceMargin decimal.Decimal
+	Excess            decimal.Decimal
+}
+
+// Parse parse the whole bill content into a Statement, the error is a *ParseError
+// when the bill is malformed
+func Parse(content string) (Statement, error) {
+	var st Statement
+	var err error
+
+	if st.Header, err = GetBillBaseInfo(content); err != nil {
+		return st, err
+	}
+	if st.Trades, err = parseTrades(content); err != nil {
+		return st, err
+	}
+	if st.OpenPositions, err = parseOpenPositions(content); err != nil {
+		return st, err
+	}
+	if st.OpenLots, err = parseOpenLots(content); err != nil {
+		return st, err
+	}
+	if st.Balances, err = parseBalances(content); err != nil {
+		return st, err
+	}
+	if st.Journal, err = parseJournal(content); err != nil {
+		return st, err
+	}
+	if st.ClosedPositions, err = parseClosedPositions(content); err != nil {
+		return st, err
+	}
+	if st.TradeSummaries, err = parseTradeSummaries(content); err != nil {
+		return st, err
+	}
+	if st.PositionSummaries, err = parsePositionSummaries(content); err != nil {
+		return st, err
+	}
+
+	return st, nil
+}
+
+func parseTrades(content string) ([]Trade, error) {
+	result := []Trade{}
+	seg := readSegment(content, "Trade Confirmation")
+	for _, row := range seg.records() {
+		r := seg.reader(row)
+		t := Trade{
+			Date:       r.date(0),
+			Market:     r.text(1),
+			Product:    r.text(2),
+			Contract:   r.text(3),
+			OpenClose:  r.text(4),
+			FocusClose: r.text(5),
+			BuySale:    r.text(6),
+			MatchQty:   r.qty(7),
+			MatchPrice: r.amount(8),
+			Premium:    r.amount(9),
+			Fee:        r.amount(10),
+			Currency:   r.text(11),
+			Remarks:    r.optional(12),
+			Time:       r.optionalTime(13, "2006-01-02 15:04:05"),
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		t.Instrument = decodeInstrument(t.Product, t.Contract)
+		result = append(result, t)
+	}
+
+	return result, nil
+}
+
+func parseOpenPositions(content string) ([]OpenPosition, error) {
+	result := []OpenPosition{}
+	seg := readSegment(content, "Gathered Open Positions")
+	for _, row := range seg.records() {
+		r := seg.reader(row)
+		p := OpenPosition{
+			Market:            r.text(0),
+			Product:           r.text(1),
+			Contract:          r.text(2),
+			Buy:               r.qty(3),
+			Sale:              r.qty(4),
+			MatchPrice:        r.amount(5),
+			SettlementPrice:   r.amount(6),
+			PositionProfit:    r.amount(7),
+			OptionMarketValue: r.amount(8),
+			Margin:            r.amount(9),
+			Currency:          r.text(10),
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		p.Instrument = decodeInstrument(p.Product, p.Contract)
+		result = append(result, p)
+	}
+
+	return result, nil
+}
+
+func parseOpenLots(content string) ([]OpenLot, error) {
+	result := []OpenLot{}
+	seg := readSegment(content, "Detailed Open Positions")
+	for _, row := range seg.records() {
+		r := seg.reader(row)
+		l := OpenLot{
+			Date:              r.date(0),
+			Market:            r.text(1),
+			Product:           r.text(2),
+			Contract:          r.text(3),
+			Buy:               r.qty(4),
+			Sale:              r.qty(5),
+			MatchPrice:        r.amount(6),
+			LastSettlement:    r.amount(7),
+			SettlementPrice:   r.amount(8),
+			CurrentProfit:     r.amount(9),
+			OptionMarketValue: r.amount(10),
+			Currency:          r.text(11),
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		l.Instrument = decodeInstrument(l.Product, l.Contract)
+		result = append(result, l)
+	}
+
+	return result, nil
+}
+
+func parseJournal(content string) ([]JournalEntry, error) {
+	result := []JournalEntry{}
+	seg := readSegment(content, "Journal Description")
+	for _, row := range seg.records() {
+		r := seg.reader(row)
+		e := JournalEntry{
+			Date:     r.date(0),
+			CashIn:   r.amount(1),
+			CashOut:  r.amount(2),
+			Type:     r.text(3),
+			Currency: r.text(4),
+			Remarks:  r.optional(5),
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		result = append(result, e)
+	}
+
+	return result, nil
+}
+
+func parseClosedPositions(content string) ([]ClosedPosition, error) {
+	result := []ClosedPosition{}
+	seg := readSegment(content, "Close Positions")
+	for _, row := range seg.records() {
+		r := seg.reader(row)
+		c := ClosedPosition{
+			Date:          r.date(0),
+			Market:        r.text(1),
+			Product:       r.text(2),
+			Contract:      r.text(3),
+			BuySale:       r.text(4),
+			Qty:           r.qty(5),
+			OpenPrice:     r.amount(6),
+			ClosePrice:    r.amount(7),
+			SettlePrice:   r.amount(8),
+			CurrentProfit: r.amount(9),
+			Currency:      r.text(10),
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		result = append(result, c)
+	}
+
+	return result, nil
+}
+
+func parseTradeSummaries(content string) ([]TradeSummary, error) {
+	result := []TradeSummary{}
+	seg := readSegment(content, "Trade Confirmation")
+	for _, row := range seg.summaries() {
+		r := seg.reader(row)
+		s := TradeSummary{
+			MatchQty: r.qty(7),
+			Premium:  r.amount(9),
+			Fee:      r.amount(10),
+			Currency: r.text(11),
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		result = append(result, s)
+	}
+
+	return result, nil
+}
+
+func parsePositionSummaries(content string) ([]PositionSummary, error) {
+	result := []PositionSummary{}
+	seg := readSegment(content, "Gathered Open Positions")
+	for _, row := range seg.summaries() {
+		r := seg.reader(row)
+		s := PositionSummary{
+			Buy:               r.qty(3),
+			Sale:              r.qty(4),
+			PositionProfit:    r.amount(7),
+			OptionMarketValue: r.amount(8),
+			Margin:            r.amount(9),
+			Currency:          r.text(10),
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		result = append(result, s)
+	}
+
+	return result, nil
+}
+
+// singleCurrency the currency of a bill without the Currency row
+const singleCurrency = "CNY"
+
+// balanceTitles the Financial Situation rows read by setBalance
+var balanceTitles = map[string]bool{
+	"Exchange": true, "Opening": true, "Deposit/Withdrawal": true, "Journal": true,
+	"Commissions": true, "Trading": true, "Delivery": true, "Option": true,
+	"Closing": true, "Unrealized": true, "Floating": true, "Equity": true,
+	"PreEquity": true, "Initial": true, "Maintenance": true, "Excess": true,
+}
+
+// parseBalances read every currency column of Financial Situation segment.
+// The columns are named by the Currency row, the first one is the base
+// currency. Without the Currency row the first column is a singleCurrency
+// balance. The rows not in balanceTitles are not read.
+func parseBalances(content string) ([]Balance, error) {
+	seg := readSegment(content, "Financial Situation")
+
+	// column index => balance
+	columns := []int{}
+	result := []Balance{}
+	for _, row := range seg.rows {
+		if strings.TrimSpace(row.cells[0]) != "Currency" {
+			continue
+		}
+		for i := 1; i < len(row.cells); i++ {
+			c := strings.TrimSpace(row.cells[i])
+			if c == "" {
+				continue
+			}
+			b := Balance{Currency: c}
+			if c == "BaseCurrency" {
+				b.Currency, b.Base = BaseCurrency, true
+			}
+			columns = append(columns, i)
+			result = append(result, b)
+		}
+		break
+	}
+	if len(columns) == 0 && len(seg.rows) > 0 {
+		columns = append(columns, 1)
+		result = append(result, Balance{Currency: singleCurrency})
+	}
+
+	// Some titles (Option) appear twice, the second one is the option market value
+	seen := make(map[string]bool)
+	for _, row := range seg.rows {
+		r := seg.reader(row)
+		title := r.text(0)
+		if !balanceTitles[title] {
+			continue
+		}
+		for n, i := range columns {
+			if i >= len(row.cells) {
+				continue
+			}
+			if v := r.amount(i); r.err == nil {
+				setBalance(&result[n], title, v, seen[title])
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		seen[title] = true
+	}
+
+	return result, nil
+}
+
+func setBalance(b *Balance, title string, v decimal.Decimal, again bool) {
+	switch title {
+	case "Exchange":
+		b.ExchangeRate = v
+	case "Opening":
+		b.Opening = v
+	case "Deposit/Withdrawal":
+		b.DepositWithdrawal = v
+	case "Journal":
+		b.Journal = v
+	case "Commissions":
+		b.Commissions = v
+	case "Trading":
+		b.Trading = v
+	case "Delivery":
+		b.Delivery = v
+	case "Option":
+		if again {
+			b.OptionMarketValue = v
+		} else {
+			b.OptionPremium = v
+		}
+	case "Closing":
+		b.Closing = v
+	case "Unrealized":
+		b.Unrealized = v
+	case "Floating":
+		b.Floating = v
+	case "Equity":
+		b.Equity = v
+	case "PreEquity":
+		b.PreEquity = v
+	case "Initial":
+		b.InitialMargin = v
+	case "Maintenance":
+		b.MaintenanceMargin = v
+	case "Excess":
+		b.Excess = v
+	}
+}
+
+// decodeInstrument decode option contract like "m1805-C-2800", the contract
+// column may omit the product, eq: "1805-C-2800", then it is taken from product
+func decodeInstrument(product, contract string) Instrument {
+	o, ok := util.ParseOptionContract(contract)
+	if !ok {
+		return Instrument{FutOpt: Future, Underlying: product + contract, Expiry: contract}
+	}
+
+	// the pattern only matches digits
+	strike, _ := parseAmount(o.Strike)
+	underlying := o.Underlying
+	if underlying == o.Expiry {
+		underlying = product + o.Expiry
+	}
+
+	return Instrument{
+		FutOpt:      Option,
+		Underlying:  underlying,
+		Expiry:      o.Expiry,
+		StrikePrice: strike,
+		PutCall:     o.PutCall,
+	}
+}
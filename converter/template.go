@@ -0,0 +1,346 @@
+package converter
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shopspring/decimal"
+)
+
+// Template output layout of the converted statement
+type Template struct {
+	Name    string   `json:"name"`
+	Outputs []Output `json:"outputs"`
+	// Path the file the template is loaded from, empty for the default
+	Path string `json:"-"`
+}
+
+// Output a file of the template, rows are the records of Kind
+type Output struct {
+	Kind string `json:"kind"`
+	// Name of the output, the sheet name in a workbook, default is Kind
+	Name string `json:"name"`
+	// Filename pattern, placeholders: {account}, {statement_date}, {date}, {time}
+	Filename string   `json:"filename"`
+	Columns  []Column `json:"columns"`
+	// WithdrawalSign sign of the withdrawal field, SignPositive by default
+	WithdrawalSign string `json:"withdrawal_sign,omitempty"`
+}
+
+// Sign conventions of Output.WithdrawalSign
+const (
+	SignPositive = "positive"
+	SignNegative = "negative"
+)
+
+// Column a column of the output.
+// Field is the record field, Value is the constant used when Field is empty or has no value.
+// Format is a time layout for dates, or a fmt verb for amounts and prices where
+// "%'.2f" groups the thousands. It is checked against the field type on load.
+type Column struct {
+	Header string `json:"header"`
+	Field  string `json:"field,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Format string `json:"format,omitempty"`
+}
+
+//go:embed templates/wanda.json
+var defaultTemplateJSON []byte
+
+var defaultTemplate = mustParseTemplate(defaultTemplateJSON)
+
+// DefaultTemplate the WANDA layout
+func DefaultTemplate() Template {
+	return defaultTemplate
+}
+
+// LoadTemplate load template from a json file
+func LoadTemplate(filepath string) (Template, error) {
+	b, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return Template{}, err
+	}
+
+	t, err := ParseTemplate(b)
+	if err != nil {
+		return t, fmt.Errorf("%s: %v", filepath, err)
+	}
+	t.Path = filepath
+
+	return t, nil
+}
+
+// ParseTemplate parse and check template json
+func ParseTemplate(b []byte) (Template, error) {
+	var t Template
+	if err := json.Unmarshal(b, &t); err != nil {
+		return t, err
+	}
+
+	names := make(map[string]bool)
+	for i, o := range t.Outputs {
+		if _, ok := recordSets[o.Kind]; !ok {
+			return t, fmt.Errorf("unknown output kind: %s", o.Kind)
+		}
+		if o.Filename == "" {
+			return t, fmt.Errorf("%s: filename is empty", o.Kind)
+		}
+		if o.Name == "" {
+			o.Name = o.Kind
+			t.Outputs[i].Name = o.Kind
+		}
+		if err := checkName(o.Name); err != nil {
+			return t, fmt.Errorf("%s: %v", o.Kind, err)
+		}
+		if names[strings.ToLower(o.Name)] {
+			return t, fmt.Errorf("%s: duplicate name: %s", o.Kind, o.Name)
+		}
+		names[strings.ToLower(o.Name)] = true
+		if s := o.WithdrawalSign; s != "" && s != SignPositive && s != SignNegative {
+			return t, fmt.Errorf("%s: unknown withdrawal sign: %s", o.Kind, s)
+		}
+
+		fields := make(map[string]bool)
+		for _, f := range Fields(o.Kind) {
+			fields[f] = true
+		}
+		for _, c := range o.Columns {
+			if c.Field != "" && !fields[c.Field] {
+				return t, fmt.Errorf("%s: unknown field: %s", o.Kind, c.Field)
+			}
+			if err := c.checkFormat(); err != nil {
+				return t, fmt.Errorf("%s: %s: %v", o.Kind, c.Header, err)
+			}
+		}
+	}
+
+	return t, nil
+}
+
+// checkName check the output name is a valid Excel sheet name, as it names
+// the sheet of the output in a workbook
+func checkName(name string) error {
+	if utf8.RuneCountInString(name) > 31 {
+		return fmt.Errorf("name is longer than 31 characters: %s", name)
+	}
+	if strings.ContainsAny(name, `:\/?*[]`) {
+		return fmt.Errorf(`name contains any of :\/?*[]: %s`, name)
+	}
+	if strings.HasPrefix(name, "'") || strings.HasSuffix(name, "'") {
+		return fmt.Errorf("name starts or ends with an apostrophe: %s", name)
+	}
+	return nil
+}
+
+func mustParseTemplate(b []byte) Template {
+	t, err := ParseTemplate(b)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Output find the output of kind
+func (t Template) Output(kind string) (Output, bool) {
+	for _, o := range t.Outputs {
+		if o.Kind == kind {
+			return o, true
+		}
+	}
+	return Output{}, false
+}
+
+// FileName build the file name of the output
+func (o Output) FileName(bill BillBaseInfo, now time.Time) string {
+	r := strings.NewReplacer(
+		"{account}", bill.AccountNo,
+		"{statement_date}", bill.StatementDateEnd.Format("20060102"),
+		"{date}", now.Format("20060102"),
+		"{time}", now.Format("20060102150405"),
+	)
+	return r.Replace(o.Filename)
+}
+
+// Header column headers of the output
+func (o Output) Header() []string {
+	result := []string{}
+	for _, c := range o.Columns {
+		result = append(result, c.Header)
+	}
+	return result
+}
+
+// Keys names of the columns in typed records such as json: the field, or the
+// header of a constant column. A field repeated in the columns is keyed once,
+// the key of a later column is empty.
+func (o Output) Keys() []string {
+	result := []string{}
+	seen := make(map[string]bool)
+	for _, c := range o.Columns {
+		switch {
+		case c.Field == "":
+			result = append(result, c.Header)
+		case seen[c.Field]:
+			result = append(result, "")
+		default:
+			seen[c.Field] = true
+			result = append(result, c.Field)
+		}
+	}
+	return result
+}
+
+// Rows convert statement to rows of the output, the first row is header
+func (o Output) Rows(st Statement) [][]string {
+	result := [][]string{o.Header()}
+	for _, r := range o.records(st) {
+		row := []string{}
+		for _, c := range o.Columns {
+			row = append(row, c.format(r))
+		}
+		result = append(result, row)
+	}
+
+	return result
+}
+
+// Values convert statement to typed rows of the output, the first row is
+// header. Amounts and prices are decimal.Decimal, dates are time.Time,
+// constant values and the other fields are string. A typed field without a
+// value is nil.
+func (o Output) Values(st Statement) [][]interface{} {
+	header := []interface{}{}
+	for _, h := range o.Header() {
+		header = append(header, h)
+	}
+
+	result := [][]interface{}{header}
+	for _, r := range o.records(st) {
+		row := []interface{}{}
+		for _, c := range o.Columns {
+			row = append(row, c.value(r))
+		}
+		result = append(result, row)
+	}
+
+	return result
+}
+
+// checkFormat check Format suits the type of the field: a fmt verb of a
+// number for amounts and prices, a time layout for dates and times
+func (c Column) checkFormat() error {
+	if c.Format == "" {
+		return nil
+	}
+
+	switch t := FieldType(c.Field); t {
+	case TypeAmount, TypePrice:
+		verb := strings.Replace(c.Format, "%'", "%", 1)
+		if strings.Contains(fmt.Sprintf(verb, 1.5), "%!") {
+			return fmt.Errorf("invalid number format: %s", c.Format)
+		}
+	case TypeDate, TypeTime:
+		d := time.Date(2017, 12, 12, 9, 30, 15, 0, time.UTC)
+		if strings.Contains(c.Format, "%") || d.Format(c.Format) == c.Format {
+			return fmt.Errorf("invalid time layout: %s", c.Format)
+		}
+	default:
+		return fmt.Errorf("format is not supported on %s field: %s", t, c.Format)
+	}
+
+	return nil
+}
+
+// records the records of the output kind in the sign convention of the output
+func (o Output) records(st Statement) []Record {
+	result := Records(o.Kind, st)
+	if o.WithdrawalSign != SignNegative {
+		return result
+	}
+	for _, r := range result {
+		if v, ok := r["withdrawal"].(amount); ok {
+			r["withdrawal"] = amount(decimal.Decimal(v).Neg())
+		}
+	}
+	return result
+}
+
+func (c Column) value(r Record) interface{} {
+	if c.Field == "" {
+		return c.Value
+	}
+
+	switch v := r[c.Field].(type) {
+	case string:
+		if v == "" {
+			return c.Value
+		}
+		return v
+	case int, bool:
+		return v
+	case amount:
+		return decimal.Decimal(v)
+	case price:
+		return decimal.Decimal(v)
+	case time.Time:
+		if v.IsZero() {
+			return c.missing()
+		}
+		return v
+	}
+
+	return c.missing()
+}
+
+// missing the value of a column without the field value, nil for a typed
+// field unless the column has a constant Value
+func (c Column) missing() interface{} {
+	if c.Value == "" && FieldType(c.Field) != TypeString {
+		return nil
+	}
+	return c.Value
+}
+
+func (c Column) format(r Record) string {
+	if c.Field == "" {
+		return c.Value
+	}
+
+	switch v := r[c.Field].(type) {
+	case string:
+		if v == "" {
+			return c.Value
+		}
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case amount:
+		if c.Format != "" {
+			return formatDecimal(decimal.Decimal(v), c.Format)
+		}
+		return formatAmount(decimal.Decimal(v))
+	case price:
+		if c.Format != "" {
+			return formatDecimal(decimal.Decimal(v), c.Format)
+		}
+		return formatPrice(decimal.Decimal(v))
+	case time.Time:
+		if v.IsZero() {
+			return c.Value
+		}
+		if c.Format != "" {
+			return v.Format(c.Format)
+		}
+		return v.Format("2006-01-02")
+	}
+
+	return c.Value
+}
@@ -0,0 +1,127 @@
+package converter
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// Checks of Discrepancy.Check
+const (
+	// CheckBalance Opening + Deposit/Withdrawal + Journal - Commissions + Trading
+	// + Delivery + Option = Closing, per currency
+	CheckBalance = "balance"
+	// CheckEquity Closing + Floating = Equity, per currency
+	CheckEquity = "equity"
+	// CheckTrades the Trade Confirmation Summary row equals the sum of the trades
+	CheckTrades = "trades"
+	// CheckPositions a Gathered Open Position equals the sum of its Detailed Open
+	// Positions, if the bill has them
+	CheckPositions = "positions"
+	// CheckFloating the Gathered Open Positions Summary profit equals Floating
+	CheckFloating = "floating"
+)
+
+// Discrepancy a failed consistency check of a bill
+type Discrepancy struct {
+	Account string
+	Check   string
+	// Item the currency, or the market, product, contract and currency of
+	// CheckPositions
+	Item  string
+	Field string
+	// Expected value computed from the other rows or segments
+	Expected decimal.Decimal
+	// Actual value stated in the bill
+	Actual decimal.Decimal
+}
+
+func (d Discrepancy) String() string {
+	return fmt.Sprintf("account %s: %s %s: %s expected %s, but got %s",
+		d.Account, d.Check, d.Item, d.Field, d.Expected, d.Actual)
+}
+
+// Validate cross check the segments of a statement, every discrepancy is
+// returned in the order of the checks
+func Validate(st Statement) []Discrepancy {
+	result := []Discrepancy{}
+	add := func(check, item, field string, expected, actual decimal.Decimal) {
+		if !expected.Equal(actual) {
+			result = append(result, Discrepancy{st.Header.AccountNo, check, item, field, expected, actual})
+		}
+	}
+
+	for _, b := range st.Balances {
+		closing := b.Opening.Add(b.DepositWithdrawal).Add(b.Journal).Sub(b.Commissions).
+			Add(b.Trading).Add(b.Delivery).Add(b.OptionPremium)
+		add(CheckBalance, b.Currency, "Closing", closing, b.Closing)
+		add(CheckEquity, b.Currency, "Equity", b.Closing.Add(b.Floating), b.Equity)
+	}
+
+	for _, s := range st.TradeSummaries {
+		var qty int
+		fee := decimal.Zero
+		for _, t := range st.Trades {
+			if t.Currency == s.Currency {
+				qty += t.MatchQty
+				fee = fee.Add(t.Fee)
+			}
+		}
+		add(CheckTrades, s.Currency, "MatchQty", decimal.NewFromInt(int64(qty)), decimal.NewFromInt(int64(s.MatchQty)))
+		add(CheckTrades, s.Currency, "Fee", fee, s.Fee)
+	}
+
+	// Gathered and detailed positions keyed by market, product, contract and
+	// currency. A bill without Detailed Open Positions has no lots to check the
+	// gathered positions against.
+	positions := st.OpenPositions
+	if len(st.OpenLots) == 0 {
+		positions = nil
+	}
+	keys := []string{}
+	gathered := make(map[string]*OpenPosition)
+	detailed := make(map[string]*OpenPosition)
+	for i, p := range positions {
+		key := p.Market + " " + p.Product + " " + p.Contract + " " + p.Currency
+		if _, ok := gathered[key]; !ok {
+			keys = append(keys, key)
+		}
+		gathered[key] = &positions[i]
+	}
+	for _, l := range st.OpenLots {
+		key := l.Market + " " + l.Product + " " + l.Contract + " " + l.Currency
+		p, ok := detailed[key]
+		if !ok {
+			if _, ok := gathered[key]; !ok {
+				keys = append(keys, key)
+			}
+			p = &OpenPosition{}
+			detailed[key] = p
+		}
+		p.Buy += l.Buy
+		p.Sale += l.Sale
+		p.PositionProfit = p.PositionProfit.Add(l.CurrentProfit)
+	}
+	for _, key := range keys {
+		g, d := gathered[key], detailed[key]
+		if g == nil {
+			g = &OpenPosition{}
+		}
+		if d == nil {
+			d = &OpenPosition{}
+		}
+		add(CheckPositions, key, "Buy", decimal.NewFromInt(int64(d.Buy)), decimal.NewFromInt(int64(g.Buy)))
+		add(CheckPositions, key, "Sale", decimal.NewFromInt(int64(d.Sale)), decimal.NewFromInt(int64(g.Sale)))
+		add(CheckPositions, key, "Position Profit", d.PositionProfit, g.PositionProfit)
+	}
+
+	for _, s := range st.PositionSummaries {
+		for _, b := range st.Balances {
+			if !b.Base && b.Currency == s.Currency {
+				add(CheckFloating, s.Currency, "Floating", s.PositionProfit, b.Floating)
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,122 @@
+package input
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// IsArchive whether the file is a .zip, .tar.gz, .tgz or .gz archive
+func IsArchive(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range []string{".zip", ".tar.gz", ".tgz", ".gz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadArchive call fn with the name and content of each file of the archive
+// in order, names use slash separator. The only member of a .gz file is named
+// after the archive without the extension. An error of fn stops the reading.
+func ReadArchive(filepath string, fn func(name string, b []byte) error) error {
+	lower := strings.ToLower(filepath)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return readZip(filepath, fn)
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return readTarGz(filepath, fn)
+	default:
+		return readGz(filepath, fn)
+	}
+}
+
+func readZip(filepath string, fn func(name string, b []byte) error) error {
+	r, err := zip.OpenReader(filepath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if err := fn(f.Name, b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readTarGz(filepath string, fn func(name string, b []byte) error) error {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		if err := fn(strings.TrimPrefix(h.Name, "./"), b); err != nil {
+			return err
+		}
+	}
+}
+
+func readGz(filepath string, fn func(name string, b []byte) error) error {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	b, err := ioutil.ReadAll(gz)
+	if err != nil {
+		return err
+	}
+	name := path.Base(strings.Replace(filepath, "\\", "/", -1))
+	return fn(name[:len(name)-len(".gz")], b)
+}
@@ -1,34 +1,131 @@
 package input
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
-	"os"
 	"strings"
+	"unicode/utf8"
 
+	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/simplifiedchinese"
-	"golang.org/x/text/transform"
+	"golang.org/x/text/encoding/unicode"
 )
 
-// RetriveBillContent read bill content from file path
+// Encodings of the bill files
+const (
+	// EncodingAuto detect the encoding from the content
+	EncodingAuto    = "auto"
+	EncodingUTF8    = "utf-8"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+	EncodingGBK     = "gbk"
+	EncodingGB18030 = "gb18030"
+)
+
+var encodings = map[string]encoding.Encoding{
+	EncodingUTF8:    unicode.UTF8BOM,
+	EncodingUTF16LE: unicode.UTF16(unicode.LittleEndian, unicode.UseBOM),
+	EncodingUTF16BE: unicode.UTF16(unicode.BigEndian, unicode.UseBOM),
+	EncodingGBK:     simplifiedchinese.GBK,
+	EncodingGB18030: simplifiedchinese.GB18030,
+}
+
+// CheckEncoding check the encoding name is supported
+func CheckEncoding(name string) error {
+	if _, ok := encodings[name]; !ok && name != EncodingAuto {
+		return fmt.Errorf("unknown encoding: %s", name)
+	}
+	return nil
+}
+
+// RetriveBillContent read bill content from file path, the encoding is detected
 func RetriveBillContent(filepath string) (string, error) {
-	var content string
-	f, err := os.Open(filepath)
-	defer f.Close()
-	if err == nil {
-		ts := simplifiedchinese.GBK.NewDecoder()
-		r := transform.NewReader(f, ts)
-		b, err := ioutil.ReadAll(r)
-		if err != nil {
-			return "", err
-		}
+	content, _, err := ReadBill(filepath, EncodingAuto)
+	return content, err
+}
+
+// ReadBill read bill content from file path decoded with the encoding, and
+// return the encoding used. EncodingAuto detects it from the content.
+func ReadBill(filepath, name string) (string, string, error) {
+	b, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return "", "", err
+	}
+
+	return DecodeBill(b, name)
+}
+
+// DecodeBill decode bill content with the encoding, and return the encoding
+// used. EncodingAuto detects it from the content.
+func DecodeBill(b []byte, name string) (string, string, error) {
+	if name == EncodingAuto {
+		name = DetectEncoding(b)
+	}
+	enc, ok := encodings[name]
+	if !ok {
+		return "", name, fmt.Errorf("unknown encoding: %s", name)
+	}
+	b, err := enc.NewDecoder().Bytes(b)
+	if err != nil {
+		return "", name, err
+	}
+
+	content := string(b)
+	if content = strings.TrimSpace(content); strings.HasSuffix(content, "------") {
+		content += "\r\n"
+	} else {
+		content += "\r\n\t-------\r\n"
+	}
+
+	return content, name, nil
+}
 
-		content = string(b)
-		if content = strings.TrimSpace(content); strings.HasSuffix(content, "------") {
-			content += "\r\n"
+// DetectEncoding guess the encoding of a bill: a byte order mark, utf-16 zero
+// bytes, valid utf-8, then GB18030 four-byte sequences, otherwise GBK
+func DetectEncoding(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xEF, 0xBB, 0xBF}):
+		return EncodingUTF8
+	case bytes.HasPrefix(b, []byte{0xFF, 0xFE}):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(b, []byte{0xFE, 0xFF}):
+		return EncodingUTF16BE
+	}
+
+	// The bills are mostly ascii, in utf-16 half of the bytes are zero
+	var even, odd int
+	for i, c := range b {
+		if c != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			even++
 		} else {
-			content += "\r\n\t-------\r\n"
+			odd++
 		}
 	}
+	if odd > len(b)/4 && odd > even {
+		return EncodingUTF16LE
+	}
+	if even > len(b)/4 && even > odd {
+		return EncodingUTF16BE
+	}
 
-	return content, err
+	if utf8.Valid(b) {
+		return EncodingUTF8
+	}
+
+	for i := 0; i+1 < len(b); i++ {
+		if b[i] < 0x80 {
+			continue
+		}
+		// A GB18030 four-byte sequence has a digit as the second byte
+		if b[i] >= 0x81 && b[i] <= 0xFE && b[i+1] >= 0x30 && b[i+1] <= 0x39 {
+			return EncodingGB18030
+		}
+		i++
+	}
+
+	return EncodingGBK
 }
@@ -0,0 +1,30 @@
+package input
+
+import (
+	"testing"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func TestDetectEncoding(t *testing.T) {
+	text := "Account No：61188803 客户名称"
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().String(text)
+	gb18030, _ := simplifiedchinese.GB18030.NewEncoder().String(text + " ÿ")
+
+	tests := map[string]string{
+		text:                   EncodingUTF8,
+		"\xEF\xBB\xBF" + text:  EncodingUTF8,
+		"\xFF\xFEA\x00c\x00":   EncodingUTF16LE,
+		"\xFE\xFF\x00A\x00c":   EncodingUTF16BE,
+		"A\x00c\x00c\x00o\x00": EncodingUTF16LE,
+		"\x00A\x00c\x00c\x00o": EncodingUTF16BE,
+		gbk:                    EncodingGBK,
+		gb18030:                EncodingGB18030,
+	}
+
+	for b, expected := range tests {
+		if encoding := DetectEncoding([]byte(b)); encoding != expected {
+			t.Errorf("Expected %q detected as %s, but got %s", b, expected, encoding)
+		}
+	}
+}
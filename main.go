@@ -5,22 +5,190 @@
 // input: read bill file content from src folder
 // convert: extract the segments of [Trade Confirmation], [Gathered Open Positions], [Financial Situation] ...
 // output: write segments into csv file
+//
+// Usage:
+// 		billconverter [flags]           convert the bills of src once
+// 		billconverter watch [flags]     convert the bills as they land in src
+// 		billconverter validate [flags]  check the segments of the bills of src are consistent
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/fengdu/billconverter/converter"
+	"github.com/fengdu/billconverter/input"
 	"github.com/fengdu/billconverter/worker"
 )
 
 func main() {
-	src := flag.String("src", "./src", "src folder")
+	src := flag.String("src", "./src", "src folder, or a .zip, .tar.gz or .gz archive of bills, - reads one bill from stdin and writes to stdout")
 	destination := flag.String("dst", "./dst", "dst folder")
+	template := flag.String("template", "", "output template json file, default is the WANDA layout")
+	mode := flag.String("mode", worker.ModeFail, "existing files of dst: fail, overwrite or dated (write into a dated subfolder)")
+	workers := flag.Int("workers", runtime.NumCPU(), "number of files converted concurrently")
+	failFast := flag.Bool("failfast", false, "stop converting after the first failed file")
+	recursive := flag.Bool("recursive", false, "scan the subfolders of src")
+	include := flag.String("include", worker.DefaultInclude, "comma separated glob patterns of the bills, a pattern with / matches the path relative to src. Archives (.zip, .tar.gz, .tgz, .gz) are always read, the patterns select the bills inside them")
+	exclude := flag.String("exclude", "", "comma separated glob patterns of the skipped files and folders")
+	mirror := flag.Bool("mirror", false, "mirror the subfolders of src under dst")
+	ledger := flag.String("ledger", "", "ledger file of the converted bills, a bill converted with the same content into the same outputs (format, dst, db, template, positions and mode) is skipped, default disables it")
+	force := flag.Bool("force", false, "convert the bills in the ledger again")
+	database := flag.String("db", "", "SQLite file the statements are written into, empty disables it")
+	format := flag.String("format", worker.FormatCSV, "comma separated output formats: csv, xlsx, json, ndjson or parquet")
+	encoding := flag.String("encoding", input.EncodingAuto, "encoding of the bills: auto, utf-8, utf-16le, utf-16be, gbk or gb18030")
+	segment := flag.String("segment", "", "stdin mode: output name or kind written as csv, default writes all the outputs as json")
+	positions := flag.String("positions", worker.PositionsGathered, "open positions to write: gathered, detailed or both")
+	interval := flag.Duration("interval", 2*time.Second, "watch: a bill is converted after its size is unchanged for an interval")
+	poll := flag.Bool("poll", false, "watch: poll src instead of filesystem notifications")
+	archive := flag.String("archive", "", "watch: folder the converted bills are moved into")
+	errors := flag.String("errors", "", "watch: folder the failed bills are moved into")
+
+	var command string
+	if len(os.Args) > 1 && (os.Args[1] == "watch" || os.Args[1] == "validate") {
+		command = os.Args[1]
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
+	watch, validate := command == "watch", command == "validate"
+	switch *positions {
+	case worker.PositionsGathered, worker.PositionsDetailed, worker.PositionsBoth:
+	default:
+		fmt.Printf("ERROR: unknown positions: %s\n", *positions)
+		os.Exit(2)
+	}
+	switch *mode {
+	case worker.ModeFail, worker.ModeOverwrite, worker.ModeDated:
+	default:
+		fmt.Printf("ERROR: unknown mode: %s\n", *mode)
+		os.Exit(2)
+	}
+	formats := splitList(*format)
+	for _, f := range formats {
+		switch f {
+		case worker.FormatCSV, worker.FormatXLSX, worker.FormatJSON, worker.FormatNDJSON, worker.FormatParquet:
+		default:
+			fmt.Printf("ERROR: unknown format: %s\n", f)
+			os.Exit(2)
+		}
+	}
+	if err := input.CheckEncoding(*encoding); err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		os.Exit(2)
+	}
+	if validate && *src == "-" {
+		fmt.Println("ERROR: validate: src - is not supported")
+		os.Exit(2)
+	}
+	if watch && *mode == worker.ModeDated {
+		fmt.Printf("ERROR: watch: mode %s is not supported\n", *mode)
+		os.Exit(2)
+	}
+
+	includes, excludes := splitList(*include), splitList(*exclude)
+	for _, patterns := range [][]string{includes, excludes} {
+		if err := worker.CheckPatterns(patterns); err != nil {
+			fmt.Printf("ERROR: pattern: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	opts := worker.Options{
+		Positions: *positions,
+		Template:  converter.DefaultTemplate(),
+		FailFast:  *failFast,
+		Mode:      *mode,
+		Workers:   *workers,
+		Recursive: *recursive,
+		Include:   includes,
+		Exclude:   excludes,
+		Mirror:    *mirror,
+		Ledger:    *ledger,
+		Force:     *force,
+		Encoding:  *encoding,
+		Formats:   formats,
+		Database:  *database,
+	}
+	if *template != "" {
+		t, err := converter.LoadTemplate(*template)
+		if err != nil {
+			fmt.Printf("ERROR: LoadTemplate: %v\n", err)
+			os.Exit(2)
+		}
+		opts.Template = t
+	}
+
+	// Stdout has only the converted bill
+	if *src == "-" {
+		if err := worker.Stream(os.Stdin, os.Stdout, *segment, opts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	flag.Parse()
 	fmt.Printf("Src folder: %s\n", *src)
-	fmt.Printf("Destination folder: %s\n", *destination)
+	if !validate {
+		fmt.Printf("Destination folder: %s\n", *destination)
+	}
+
+	// Ctrl-C stops queuing files, the in-flight ones finish, and stops watching
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// Restore the default handling, a second Ctrl-C quits at once
+		stop()
+	}()
+
+	if validate {
+		report, err := worker.Validate(ctx, *src, opts)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		report.Print(os.Stdout)
+		if report.Failed() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if watch {
+		wopts := worker.WatchOptions{Interval: *interval, Poll: *poll, Archive: *archive, Errors: *errors}
+		if err := worker.Watch(ctx, *src, *destination, opts, wopts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	report, err := worker.Start(ctx, *src, *destination, opts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	report.Print(os.Stdout)
+	if report.Failed() {
+		os.Exit(1)
+	}
+}
 
-	worker.Start(*src, *destination)
+func splitList(s string) []string {
+	result := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
 }
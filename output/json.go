@@ -0,0 +1,120 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Section a named segment of typed values, the first row is header
+type Section struct {
+	Name string
+	Rows [][]interface{}
+}
+
+// Field a named value of the bill header
+type Field struct {
+	Name  string
+	Value interface{}
+}
+
+// NDJSONType the field of a ndjson record naming its section
+const NDJSONType = "record_type"
+
+// WriteJSON write a bill as a json object, the header fields under "header"
+// then the sections keyed by name in order. A section is an array of objects
+// keyed by the section header, a nil value is null, dates are written as yyyy-mm-dd and times as
+// yyyy-mm-dd hh:mm:ss.
+func WriteJSON(w io.Writer, header []Field, sections []Section) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("{\n  \"header\": ")
+	writeFields(bw, header)
+	for _, s := range sections {
+		bw.WriteString(",\n  ")
+		writeValue(bw, s.Name)
+		bw.WriteString(": [")
+		for i, row := range records(s.Rows) {
+			if i > 0 {
+				bw.WriteString(",")
+			}
+			bw.WriteString("\n    ")
+			writeFields(bw, fields(s.Rows[0], row))
+		}
+		if len(s.Rows) > 1 {
+			bw.WriteString("\n  ")
+		}
+		bw.WriteString("]")
+	}
+	bw.WriteString("\n}\n")
+
+	return bw.Flush()
+}
+
+// WriteNDJSON write a json object per line for each row of the sections,
+// NDJSONType names the section of the row
+func WriteNDJSON(w io.Writer, sections []Section) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range sections {
+		for _, row := range records(s.Rows) {
+			writeFields(bw, append([]Field{{NDJSONType, s.Name}}, fields(s.Rows[0], row)...))
+			bw.WriteString("\n")
+		}
+	}
+
+	return bw.Flush()
+}
+
+// records rows after the header
+func records(rows [][]interface{}) [][]interface{} {
+	if len(rows) == 0 {
+		return nil
+	}
+	return rows[1:]
+}
+
+// fields pair the row values with the header
+func fields(header, row []interface{}) []Field {
+	result := []Field{}
+	for i, h := range header {
+		name, _ := h.(string)
+		var v interface{}
+		if i < len(row) {
+			v = row[i]
+		}
+		result = append(result, Field{name, v})
+	}
+	return result
+}
+
+// writeFields write the fields as a json object in order
+func writeFields(w *bufio.Writer, fields []Field) {
+	w.WriteString("{")
+	for i, f := range fields {
+		if i > 0 {
+			w.WriteString(", ")
+		}
+		writeValue(w, f.Name)
+		w.WriteString(": ")
+		writeValue(w, f.Value)
+	}
+	w.WriteString("}")
+}
+
+func writeValue(w *bufio.Writer, v interface{}) {
+	switch t := v.(type) {
+	case time.Time:
+		v = formatTime(t)
+	case decimal.Decimal:
+		// a json number with the exact digits
+		w.WriteString(t.String())
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b = []byte("null")
+	}
+	w.Write(b)
+}
@@ -2,24 +2,132 @@ package output
 
 import (
 	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"time"
 )
 
-// Write to csv file
-func Write(filepath string, segment [][]string) error {
-	f, err := os.Create(filepath)
-	defer f.Close()
+// Write to csv file. The csv is written to a temp file in the same folder then
+// renamed, so a crash never leaves a truncated csv.
+func Write(fp string, segment [][]string) error {
+	return WriteFile(fp, func(w io.Writer) error {
+		return WriteCSV(w, segment)
+	})
+}
+
+// WriteFile write the file with fn, to a temp file in the same folder then renamed
+func WriteFile(fp string, fn func(w io.Writer) error) error {
+	temp, err := writeTemp(fp, fn)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+
+	return os.Rename(temp, fp)
+}
+
+// Batch the output files of a bill, written to temp files then moved to their
+// paths together by Commit, so a failed bill leaves none of its files
+type Batch struct {
+	// Overwrite replace the existing files, otherwise Commit fails on an
+	// existing file and never replaces it
+	Overwrite bool
+	files     []batchFile
+}
+
+type batchFile struct {
+	temp, fp string
+}
+
+// WriteFile write the file fp with fn into a temp file in the same folder
+func (b *Batch) WriteFile(fp string, fn func(w io.Writer) error) error {
+	temp, err := writeTemp(fp, fn)
 	if err != nil {
 		return err
 	}
+	b.files = append(b.files, batchFile{temp, fp})
+	return nil
+}
+
+// Commit move the temp files to their paths. Without Overwrite a file is
+// hard linked, which fails if the path exists even if another writer has just
+// created it. A file which fails removes the files moved before it.
+func (b *Batch) Commit() error {
+	defer b.Abort()
 
-	w := csv.NewWriter(f)
+	done := []string{}
+	for _, f := range b.files {
+		var err error
+		if b.Overwrite {
+			err = os.Rename(f.temp, f.fp)
+		} else if err = os.Link(f.temp, f.fp); os.IsExist(err) {
+			err = fmt.Errorf("%s: file exists", filepath.Base(f.fp))
+		}
+		if err != nil {
+			for _, fp := range done {
+				os.Remove(fp)
+			}
+			return err
+		}
+		done = append(done, f.fp)
+	}
+	return nil
+}
+
+// Abort remove the temp files, the committed files are kept
+func (b *Batch) Abort() {
+	for _, f := range b.files {
+		os.Remove(f.temp)
+	}
+	b.files = nil
+}
+
+// writeTemp write a synced temp file in the folder of fp with fn, so a crash
+// after the rename never leaves an empty or truncated file
+func writeTemp(fp string, fn func(w io.Writer) error) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(fp), "."+filepath.Base(fp)+".tmp")
+	if err != nil {
+		return "", err
+	}
+	temp := f.Name()
+
+	err = fn(f)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(temp)
+		return "", err
+	}
+	return temp, nil
+}
+
+// WriteCSV write the segment as csv
+func WriteCSV(w io.Writer, segment [][]string) error {
+	cw := csv.NewWriter(w)
 	for _, val := range segment {
-		if err := w.Write(val); err != nil {
+		if err := cw.Write(val); err != nil {
 			break
 		}
 	}
 
-	w.Flush()
-	return w.Error()
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatTime yyyy-mm-dd, with hh:mm:ss if the time is not midnight
+func formatTime(t time.Time) string {
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02 15:04:05")
 }
@@ -0,0 +1,130 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Parquet column types of ParquetColumn
+const (
+	ParquetString = "string"
+	ParquetInt    = "int"
+	ParquetBool   = "bool"
+	// ParquetDecimal int64 decimal of ParquetColumn.Scale digits
+	ParquetDecimal = "decimal"
+	// ParquetDate days since 1970-01-01
+	ParquetDate = "date"
+	// ParquetTimestamp milliseconds since 1970-01-01 00:00:00 UTC, the wall
+	// time of the bill is in billZone
+	ParquetTimestamp = "timestamp"
+)
+
+// decimalPrecision digits of an INT64 decimal
+const decimalPrecision = 18
+
+// maxDecimal the unscaled decimals are below 10^decimalPrecision
+var maxDecimal = decimal.New(1, decimalPrecision)
+
+// billZone the bills are stated in China Standard Time, UTC+8
+var billZone = time.FixedZone("CST", 8*60*60)
+
+// ParquetColumn a column of a parquet file, every column is optional
+type ParquetColumn struct {
+	Name  string
+	Type  string
+	Scale int
+}
+
+// metadata the parquet-go schema tag of the column
+func (c ParquetColumn) metadata() string {
+	var t string
+	switch c.Type {
+	case ParquetInt:
+		t = "type=INT64"
+	case ParquetBool:
+		t = "type=BOOLEAN"
+	case ParquetDecimal:
+		t = fmt.Sprintf("type=INT64, convertedtype=DECIMAL, scale=%d, precision=%d", c.Scale, decimalPrecision)
+	case ParquetDate:
+		t = "type=INT32, convertedtype=DATE"
+	case ParquetTimestamp:
+		t = "type=INT64, convertedtype=TIMESTAMP_MILLIS"
+	default:
+		t = "type=BYTE_ARRAY, convertedtype=UTF8"
+	}
+	return fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", c.Name, t)
+}
+
+// value convert a typed value of the column to the parquet physical type, a
+// value of another type is null. A decimal with more digits than Scale, or
+// beyond the precision, is an error rather than rounded.
+func (c ParquetColumn) value(v interface{}) (interface{}, error) {
+	switch c.Type {
+	case ParquetInt:
+		if i, ok := v.(int); ok {
+			return int64(i), nil
+		}
+	case ParquetBool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case ParquetDecimal:
+		if d, ok := v.(decimal.Decimal); ok {
+			unscaled := d.Shift(int32(c.Scale))
+			if !unscaled.Equal(unscaled.Truncate(0)) {
+				return nil, fmt.Errorf("%s: %s has more than %d decimals", c.Name, d, c.Scale)
+			}
+			if unscaled.Abs().Cmp(maxDecimal) >= 0 {
+				return nil, fmt.Errorf("%s: %s exceeds %d digits", c.Name, d, decimalPrecision)
+			}
+			return unscaled.IntPart(), nil
+		}
+	case ParquetDate:
+		if t, ok := v.(time.Time); ok && !t.IsZero() {
+			d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+			return int32(d.Unix() / 86400), nil
+		}
+	case ParquetTimestamp:
+		if t, ok := v.(time.Time); ok && !t.IsZero() {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), billZone)
+			return t.UnixNano() / int64(time.Millisecond), nil
+		}
+	default:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	}
+	return nil, nil
+}
+
+// WriteParquet write the rows as a parquet file with the typed columns,
+// values are in the order of the columns
+func WriteParquet(w io.Writer, columns []ParquetColumn, rows [][]interface{}) error {
+	md := []string{}
+	for _, c := range columns {
+		md = append(md, c.metadata())
+	}
+
+	pw, err := writer.NewCSVWriterFromWriter(md, w, 1)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		rec := make([]interface{}, len(columns))
+		for i, c := range columns {
+			if i < len(row) {
+				if rec[i], err = c.value(row[i]); err != nil {
+					return err
+				}
+			}
+		}
+		if err := pw.Write(rec); err != nil {
+			return err
+		}
+	}
+	return pw.WriteStop()
+}
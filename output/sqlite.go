@@ -0,0 +1,231 @@
+package output
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	// SQLite driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// DB a SQLite database of the converted statements. Rows are keyed by account
+// and statement date, writing a statement again replaces its rows.
+type DB struct {
+	mu sync.Mutex
+	db *sql.DB
+}
+
+// Statement a converted statement written into DB
+type Statement struct {
+	Account            string
+	StatementDate      time.Time
+	StatementDateStart time.Time
+	BillDate           time.Time
+	// Tables rows of the statement tables, the columns of a table must include
+	// account and statement_date
+	Tables []Table
+}
+
+// Table rows of a table, values are in the order of the columns
+type Table struct {
+	Name    string
+	Columns []string
+	// Types declared SQLite types of the columns, INTEGER, NUMERIC or TEXT
+	Types []string
+	Rows  [][]interface{}
+}
+
+// Declared SQLite types of Table.Types
+const (
+	SQLInteger = "INTEGER"
+	// SQLNumeric a decimal text is stored as an INTEGER or a REAL, which keeps
+	// the 15 significant digits of the bills, so it compares and sums as a number
+	SQLNumeric = "NUMERIC"
+	SQLText    = "TEXT"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS accounts (
+	account TEXT PRIMARY KEY,
+	first_statement_date TEXT NOT NULL,
+	last_statement_date TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS statements (
+	account TEXT NOT NULL,
+	statement_date TEXT NOT NULL,
+	statement_date_start TEXT,
+	bill_date TEXT,
+	converted TEXT NOT NULL,
+	PRIMARY KEY (account, statement_date)
+);`
+
+// OpenDB open or create the SQLite file
+func OpenDB(fp string) (*DB, error) {
+	db, err := sql.Open("sqlite3", fp)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &DB{db: db}, nil
+}
+
+// Close close the database
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// WriteStatement upsert the statement and its tables in a transaction, rows of
+// a previous write of the statement which are not in the tables are deleted
+func (d *DB) WriteStatement(st Statement) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	date := sqlValue(st.StatementDate)
+	_, err = tx.Exec(`INSERT INTO accounts (account, first_statement_date, last_statement_date) VALUES (?, ?, ?)
+		ON CONFLICT (account) DO UPDATE SET
+		first_statement_date = min(first_statement_date, excluded.first_statement_date),
+		last_statement_date = max(last_statement_date, excluded.last_statement_date)`,
+		st.Account, date, date)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO statements (account, statement_date, statement_date_start, bill_date, converted) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (account, statement_date) DO UPDATE SET
+		statement_date_start = excluded.statement_date_start,
+		bill_date = excluded.bill_date,
+		converted = excluded.converted`,
+		st.Account, date, sqlValue(st.StatementDateStart), sqlValue(st.BillDate), time.Now().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+
+	for _, t := range st.Tables {
+		if err := writeTable(tx, t, st.Account, date); err != nil {
+			return fmt.Errorf("%s: %v", t.Name, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// writeTable upsert the rows keyed by account, statement date and the row number
+func writeTable(tx *sql.Tx, t Table, account, date interface{}) error {
+	columns := []string{"seq"}
+	definitions := []string{"seq " + SQLInteger}
+	updates := []string{}
+	for i, c := range t.Columns {
+		columns = append(columns, quote(c))
+		definitions = append(definitions, quote(c)+" "+t.columnType(i))
+		if c != "account" && c != "statement_date" {
+			updates = append(updates, fmt.Sprintf("%s = excluded.%s", quote(c), quote(c)))
+		}
+	}
+
+	_, err := tx.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s, PRIMARY KEY (account, statement_date, seq))",
+		quote(t.Name), strings.Join(definitions, ", ")))
+	if err != nil {
+		return err
+	}
+
+	// A table created by an earlier version lacks the columns added since
+	existing, err := tableColumns(tx, t.Name)
+	if err != nil {
+		return err
+	}
+	for i, c := range t.Columns {
+		if existing[strings.ToLower(c)] {
+			continue
+		}
+		_, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", quote(t.Name), quote(c), t.columnType(i)))
+		if err != nil {
+			return err
+		}
+	}
+
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (account, statement_date, seq) DO UPDATE SET %s",
+		quote(t.Name), strings.Join(columns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "), strings.Join(updates, ", "))
+	stmt, err := tx.Prepare(insert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i, row := range t.Rows {
+		args := []interface{}{i}
+		for _, v := range row {
+			args = append(args, sqlValue(v))
+		}
+		if _, err := stmt.Exec(args...); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE account = ? AND statement_date = ? AND seq >= ?", quote(t.Name)),
+		account, date, len(t.Rows))
+	return err
+}
+
+// columnType declared type of the ith column, TEXT if Types is short
+func (t Table) columnType(i int) string {
+	if i < len(t.Types) && t.Types[i] != "" {
+		return t.Types[i]
+	}
+	return SQLText
+}
+
+// tableColumns lower case names of the columns of an existing table
+func tableColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", quote(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var dflt interface{}
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		result[strings.ToLower(name)] = true
+	}
+	return result, rows.Err()
+}
+
+// sqlValue dates are stored as yyyy-mm-dd text as formatTime, a zero date is
+// null. Decimals are passed as their exact text for a SQLNumeric column.
+func sqlValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		if v.IsZero() {
+			return nil
+		}
+		return formatTime(v)
+	case decimal.Decimal:
+		return v.String()
+	}
+	return v
+}
+
+func quote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
@@ -0,0 +1,72 @@
+package output
+
+import (
+	"io"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/xuri/excelize/v2"
+)
+
+// WriteXLSX write the sections as the sheets of a xlsx workbook. Strings are
+// text cells so Excel keeps codes like 805 and account numbers as they are,
+// numbers and dates are typed cells.
+func WriteXLSX(w io.Writer, sheets []Section) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	text, err := f.NewStyle(&excelize.Style{NumFmt: 49})
+	if err != nil {
+		return err
+	}
+	dateFormat := "yyyy-mm-dd"
+	date, err := f.NewStyle(&excelize.Style{CustomNumFmt: &dateFormat})
+	if err != nil {
+		return err
+	}
+
+	for i, sheet := range sheets {
+		if i == 0 {
+			err = f.SetSheetName(f.GetSheetName(0), sheet.Name)
+		} else {
+			_, err = f.NewSheet(sheet.Name)
+		}
+		if err != nil {
+			return err
+		}
+
+		for r, row := range sheet.Rows {
+			for c, v := range row {
+				cell, err := excelize.CoordinatesToCellName(c+1, r+1)
+				if err != nil {
+					return err
+				}
+				if err := setCell(f, sheet.Name, cell, v, text, date); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	_, err = f.WriteTo(w)
+	return err
+}
+
+func setCell(f *excelize.File, sheet, cell string, v interface{}, text, date int) error {
+	switch v := v.(type) {
+	case string:
+		if err := f.SetCellStr(sheet, cell, v); err != nil {
+			return err
+		}
+		return f.SetCellStyle(sheet, cell, cell, text)
+	case time.Time:
+		if err := f.SetCellValue(sheet, cell, v); err != nil {
+			return err
+		}
+		return f.SetCellStyle(sheet, cell, cell, date)
+	case decimal.Decimal:
+		return f.SetCellValue(sheet, cell, v.InexactFloat64())
+	default:
+		return f.SetCellValue(sheet, cell, v)
+	}
+}
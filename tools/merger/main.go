@@ -6,11 +6,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/fengdu/billconverter/converter"
 	"github.com/fengdu/billconverter/output"
+	"github.com/shopspring/decimal"
 )
 
 func main() {
@@ -170,27 +171,38 @@ func mergePoses(mainBillPath, subBillPath, destination string) {
 			break
 		}
 
-		for _, line := range lines[1:] {
-			tradedate := line[1]
+		for i, line := range lines[1:] {
+			tradedate := line[converter.ColTradedate]
 			if t, err := time.Parse("2006-01-02", tradedate); err == nil {
 				tradedate = t.Format("01/02/2006")
-				line[1] = tradedate
+				line[converter.ColTradedate] = tradedate
 			}
 
-			exchange := line[5]
-			line[5] = strings.ToLower(exchange)
+			exchange := line[converter.ColExchange]
+			line[converter.ColExchange] = strings.ToLower(exchange)
 
-			contract := line[6]
-			line[6] = "c" + contract
+			contract := line[converter.ColContract]
+			line[converter.ColContract] = "c" + contract
 
-			price, _ := strconv.ParseFloat(line[10], 64)
-			line[10] = fmt.Sprintf("%.2f", price)
+			// The header is line 1 of the file
+			price, err := decimal.NewFromString(line[converter.ColPrice])
+			if err != nil {
+				fmt.Println("合并 Pos 错误")
+				fmt.Printf("%s: line %d: Price: %v\n", filename, i+2, err)
+				return
+			}
+			line[converter.ColPrice] = price.StringFixed(2)
 
-			unrealisedPL, _ := strconv.ParseFloat(strings.Replace(line[13], ",", "", -1), 64)
-			line[13] = fmt.Sprintf("%.0f", unrealisedPL)
+			unrealisedPL, err := decimal.NewFromString(strings.Replace(line[converter.ColUnrealisedPL], ",", "", -1))
+			if err != nil {
+				fmt.Println("合并 Pos 错误")
+				fmt.Printf("%s: line %d: UnrealisedPL: %v\n", filename, i+2, err)
+				return
+			}
+			line[converter.ColUnrealisedPL] = unrealisedPL.StringFixed(0)
 
-			commodity := line[17]
-			line[17] = strings.ToLower(commodity)
+			commodity := line[converter.ColCommodity]
+			line[converter.ColCommodity] = strings.ToLower(commodity)
 
 			rows = append(rows, line)
 		}
@@ -226,27 +238,27 @@ func mergeTrades(mainBillPath, subBillPath, destination string) {
 		}
 
 		for _, line := range lines[1:] {
-			tradedate := line[1]
+			tradedate := line[converter.ColTradedate]
 			if t, err := time.Parse("2006-01-02", tradedate); err == nil {
 				tradedate = t.Format("01/02/2006")
-				line[1] = tradedate
+				line[converter.ColTradedate] = tradedate
 			}
 
-			exchange := line[5]
-			line[5] = strings.ToLower(exchange)
+			exchange := line[converter.ColExchange]
+			line[converter.ColExchange] = strings.ToLower(exchange)
 
-			contract := line[6]
-			line[6] = "c" + contract
+			contract := line[converter.ColContract]
+			line[converter.ColContract] = "c" + contract
 
-			buySell := line[15]
+			buySell := line[converter.ColBuySell]
 			if strings.ToLower(buySell) == "sale" {
-				line[15] = "0"
+				line[converter.ColBuySell] = "0"
 			} else if strings.ToLower(buySell) == "buy" {
-				line[15] = "1"
+				line[converter.ColBuySell] = "1"
 			}
 
-			commodity := line[17]
-			line[17] = strings.ToLower(commodity)
+			commodity := line[converter.ColCommodity]
+			line[converter.ColCommodity] = strings.ToLower(commodity)
 
 			rows = append(rows, line)
 		}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -44,3 +45,29 @@ func ParseMonthAndYear(d string) (month string, year string, err error) {
 
 	return
 }
+
+// OptionContract option contract code parts
+type OptionContract struct {
+	Underlying string
+	Expiry     string
+	PutCall    string
+	Strike     string
+}
+
+var optionContractPattern = regexp.MustCompile(`^([A-Za-z]*)(\d{3,4})-?([CP])-?(\d+(?:\.\d+)?)$`)
+
+// ParseOptionContract parse option contract code, eq: "m1805-C-2800", "SR805C6000" or "1805-P-2800"
+func ParseOptionContract(code string) (OptionContract, bool) {
+	var result OptionContract
+	m := optionContractPattern.FindStringSubmatch(strings.TrimSpace(code))
+	if m == nil {
+		return result, false
+	}
+
+	result.Underlying = m[1] + m[2]
+	result.Expiry = m[2]
+	result.PutCall = m[3]
+	result.Strike = m[4]
+
+	return result, true
+}
@@ -195,3 +195,19 @@ func TestParseMonthAndYear(t *testing.T) {
 		t.Errorf("Expected parse from '66666' throw error, but not")
 	}
 }
+
+func TestParseOptionContract(t *testing.T) {
+	o, ok := ParseOptionContract("m1805-C-2800")
+	if !ok || o.Underlying != "m1805" || o.Expiry != "1805" || o.PutCall != "C" || o.Strike != "2800" {
+		t.Errorf("Expected m1805-C-2800 parsed as m1805 1805 C 2800, but got %v", o)
+	}
+
+	o, ok = ParseOptionContract("SR805P6000")
+	if !ok || o.Underlying != "SR805" || o.Expiry != "805" || o.PutCall != "P" || o.Strike != "6000" {
+		t.Errorf("Expected SR805P6000 parsed as SR805 805 P 6000, but got %v", o)
+	}
+
+	if _, ok = ParseOptionContract("1801"); ok {
+		t.Errorf("Expected future contract 1801 is not an option, but not")
+	}
+}
@@ -0,0 +1,127 @@
+package worker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LedgerEntry a converted bill
+type LedgerEntry struct {
+	Hash string `json:"hash"`
+	// Targets the formats, destination, database, template, positions and mode
+	// the bill was converted with, as ledgerTargets
+	Targets   string    `json:"targets"`
+	Converted time.Time `json:"converted"`
+	Outputs   []string  `json:"outputs"`
+}
+
+// Ledger processed bills keyed by the absolute source path, saved as a json file
+type Ledger struct {
+	mu       sync.Mutex
+	filepath string
+	Files    map[string]LedgerEntry `json:"files"`
+}
+
+// OpenLedger load the ledger file, a missing file is an empty ledger
+func OpenLedger(fp string) (*Ledger, error) {
+	l := &Ledger{filepath: fp, Files: make(map[string]LedgerEntry)}
+
+	b, err := ioutil.ReadFile(fp)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, l); err != nil {
+		return nil, err
+	}
+	if l.Files == nil {
+		l.Files = make(map[string]LedgerEntry)
+	}
+
+	return l, nil
+}
+
+// Converted whether the source with the hash has been converted into the
+// targets, a bill converted with other outputs is not
+func (l *Ledger) Converted(source, hash, targets string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.Files[source]
+	return ok && e.Hash == hash && e.Targets == targets
+}
+
+// Record record a converted source and save the ledger
+func (l *Ledger) Record(source, hash, targets string, outputs []string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.Files[source] = LedgerEntry{Hash: hash, Targets: targets, Converted: time.Now(), Outputs: outputs}
+
+	return l.save()
+}
+
+// save write to a temp file then rename, a crash never leaves a broken ledger
+func (l *Ledger) save() error {
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temp := l.filepath + ".tmp"
+	if err := ioutil.WriteFile(temp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, l.filepath)
+}
+
+// hashContent sha256 of the bill content
+func hashContent(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// ledgerTargets where and how the outputs of a bill are written: the formats,
+// the absolute destination and database, the template path and content hash,
+// the positions and the mode, separated by ";"
+func ledgerTargets(destination string, opts Options) string {
+	formats := opts.Formats
+	if len(formats) == 0 {
+		formats = []string{FormatCSV}
+	}
+	t := template(opts)
+	b, _ := json.Marshal(t)
+
+	return strings.Join([]string{
+		strings.Join(formats, ","), absPath(destination), absPath(opts.Database),
+		t.Path + "@" + hashContent(b), opts.Positions, opts.Mode,
+	}, ";")
+}
+
+// absPath absolute path of fp, empty stays empty
+func absPath(fp string) string {
+	if fp == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(fp); err == nil {
+		return abs
+	}
+	return fp
+}
+
+// ledgerKey absolute path of the source file, an archive member is keyed by
+// the archive path joined with the member path
+func ledgerKey(src, filename string) string {
+	fp := filepath.Join(src, filename)
+	if abs, err := filepath.Abs(fp); err == nil {
+		return abs
+	}
+	return fp
+}
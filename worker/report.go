@@ -0,0 +1,107 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Status of a src file
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusSkipped   = "skipped"
+)
+
+// Result result of a src file
+type Result struct {
+	File    string
+	Status  string
+	Reason  string
+	Outputs []string
+	// Encoding the bill is decoded with, empty if it was not read
+	Encoding string
+}
+
+// Report results of a convert or validate run
+type Report struct {
+	mu      sync.Mutex
+	Results []Result
+	// success the last line Print writes if no file failed, default is the
+	// convert one
+	success string
+}
+
+func (r *Report) add(result Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Results = append(r.Results, result)
+}
+
+// Count number of results of status
+func (r *Report) Count(status string) int {
+	var n int
+	for _, result := range r.Results {
+		if result.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Encodings number of read files of each encoding
+func (r *Report) Encodings() map[string]int {
+	counts := make(map[string]int)
+	for _, result := range r.Results {
+		if result.Encoding != "" {
+			counts[result.Encoding]++
+		}
+	}
+	return counts
+}
+
+// Failed whether any file failed
+func (r *Report) Failed() bool {
+	return r.Count(StatusFailed) > 0
+}
+
+// Print write the summary and the reason of failed and skipped files
+func (r *Report) Print(w io.Writer) {
+	sort.Slice(r.Results, func(i, j int) bool { return r.Results[i].File < r.Results[j].File })
+
+	fmt.Fprintf(w, "INFO: %d succeeded, %d failed, %d skipped.\n",
+		r.Count(StatusSucceeded), r.Count(StatusFailed), r.Count(StatusSkipped))
+	if encodings := r.Encodings(); len(encodings) > 0 {
+		names := []string{}
+		for name := range encodings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for i, name := range names {
+			names[i] = fmt.Sprintf("%s %d", name, encodings[name])
+		}
+		fmt.Fprintf(w, "INFO: encodings: %s.\n", strings.Join(names, ", "))
+	}
+	for _, result := range r.Results {
+		switch result.Status {
+		case StatusFailed:
+			if result.Encoding != "" {
+				fmt.Fprintf(w, "ERROR: %s: %s (decoded as %s)\n", result.File, result.Reason, result.Encoding)
+			} else {
+				fmt.Fprintf(w, "ERROR: %s: %s\n", result.File, result.Reason)
+			}
+		case StatusSkipped:
+			fmt.Fprintf(w, "SKIP: %s: %s\n", result.File, result.Reason)
+		}
+	}
+
+	if !r.Failed() {
+		if r.success != "" {
+			fmt.Fprintln(w, r.success)
+		} else {
+			fmt.Fprintln(w, "INFO: all file convert successed.")
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/fengdu/billconverter/input"
+)
+
+// DefaultInclude include pattern when Options.Include is empty
+const DefaultInclude = "*.txt"
+
+// scan find the bills and archives of src, paths are relative to src with slash
+// separator. Files not matching the include or matching the exclude patterns are
+// reported skipped. An archive is always read whatever the include patterns,
+// which select the bills inside it as readArchive. The skip folders are absolute paths which are not scanned.
+func scan(src string, opts Options, skip []string, report *Report) ([]string, error) {
+	include := opts.Include
+	if len(include) == 0 {
+		include = []string{DefaultInclude}
+	}
+
+	result := []string{}
+	check := func(rel string) {
+		switch {
+		case match(opts.Exclude, rel):
+			report.add(Result{File: rel, Status: StatusSkipped, Reason: "excluded"})
+		case !match(include, rel) && !input.IsArchive(rel):
+			report.add(Result{File: rel, Status: StatusSkipped, Reason: "not matching include " + strings.Join(include, ",")})
+		default:
+			result = append(result, rel)
+		}
+	}
+
+	if !opts.Recursive {
+		files, err := ioutil.ReadDir(src)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if f.IsDir() {
+				report.add(Result{File: f.Name(), Status: StatusSkipped, Reason: "directory"})
+				continue
+			}
+			check(f.Name())
+		}
+		return result, nil
+	}
+
+	err := filepath.Walk(src, func(p string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+
+		if f.IsDir() {
+			if abs, err := filepath.Abs(p); err == nil && contains(skip, abs) {
+				return filepath.SkipDir
+			}
+			if match(opts.Exclude, rel) {
+				report.add(Result{File: rel, Status: StatusSkipped, Reason: "excluded"})
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		check(rel)
+		return nil
+	})
+
+	return result, err
+}
+
+// match whether rel matches any of the glob patterns. A pattern with "/" is
+// matched against the relative path, otherwise against the file name.
+func match(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		name := path.Base(rel)
+		if strings.Contains(p, "/") {
+			name = rel
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckPatterns check the syntax of glob patterns
+func CheckPatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/fengdu/billconverter/converter"
+	"github.com/fengdu/billconverter/input"
+	"github.com/fengdu/billconverter/output"
+)
+
+// Stream convert a bill read from r and write it to w. The output named
+// segment, matched by the output name or kind of the template, is written as
+// csv. An empty segment writes the bill as the json document of FormatJSON.
+// Warnings go to stderr so w only has the converted bill.
+func Stream(r io.Reader, w io.Writer, segment string, opts Options) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("ERROR: read: %v", err)
+	}
+
+	encoding := opts.Encoding
+	if encoding == "" {
+		encoding = input.EncodingAuto
+	}
+	content, _, err := input.DecodeBill(b, encoding)
+	if err != nil {
+		return fmt.Errorf("ERROR: read: %v", err)
+	}
+
+	st, err := converter.Parse(content)
+	if err != nil {
+		return fmt.Errorf("ERROR: Parse: %v", err)
+	}
+	if err := converter.CheckJournal(st); err != nil {
+		fmt.Fprintf(os.Stderr, "WARN: %v\n", err)
+	}
+
+	if segment == "" {
+		sections := []output.Section{}
+		for _, o := range outputs(opts) {
+			sections = append(sections, section(st, o, FormatJSON))
+		}
+		return output.WriteJSON(w, headerFields(st.Header), sections)
+	}
+
+	for _, o := range template(opts).Outputs {
+		if strings.EqualFold(o.Name, segment) || o.Kind == segment {
+			return output.WriteCSV(w, o.Rows(st))
+		}
+	}
+	return fmt.Errorf("ERROR: unknown segment: %s", segment)
+}
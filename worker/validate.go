@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fengdu/billconverter/converter"
+	"github.com/fengdu/billconverter/input"
+)
+
+// Validate cross check the segments of the bills of src, src is a folder or
+// an archive as of Start. A bill with discrepancies fails, the discrepancies
+// are printed and are the reason of its result. When ctx is done the
+// remaining files are skipped.
+func Validate(ctx context.Context, src string, opts Options) (*Report, error) {
+	report := &Report{success: "INFO: all bills are consistent."}
+	var files []string
+	var err error
+	if stat, e := os.Stat(src); e == nil && !stat.IsDir() && input.IsArchive(src) {
+		src, files = filepath.Dir(src), []string{filepath.Base(src)}
+	} else if files, err = scan(src, opts, nil, report); err != nil {
+		return nil, fmt.Errorf("ERROR: ReadDir: %v", err)
+	}
+
+	for _, f := range files {
+		if ctx.Err() != nil {
+			report.add(Result{File: f, Status: StatusSkipped, Reason: "canceled"})
+			continue
+		}
+		for _, result := range readBills(f, src, opts, func(bl bill, b []byte) Result {
+			return validateBill(bl, b, opts)
+		}) {
+			report.add(result)
+		}
+	}
+
+	return report, nil
+}
+
+// validateBill check the content of a bill
+func validateBill(bl bill, b []byte, opts Options) Result {
+	st, encoding, err := parse(bl, b, opts)
+	if err != nil {
+		fmt.Println(err)
+		return Result{File: bl.name, Status: StatusFailed, Reason: err.Error(), Encoding: encoding}
+	}
+
+	discrepancies := converter.Validate(st)
+	if len(discrepancies) == 0 {
+		fmt.Printf("INFO: %s: account %s is consistent.\n", bl.name, st.Header.AccountNo)
+		return Result{File: bl.name, Status: StatusSucceeded, Encoding: encoding}
+	}
+
+	reasons := []string{}
+	for _, d := range discrepancies {
+		fmt.Printf("ERROR: %s: %v\n", bl.name, d)
+		reasons = append(reasons, d.String())
+	}
+	return Result{File: bl.name, Status: StatusFailed, Reason: strings.Join(reasons, "; "), Encoding: encoding}
+}
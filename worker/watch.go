@@ -0,0 +1,221 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/fengdu/billconverter/output"
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchOptions options of watch mode
+type WatchOptions struct {
+	// Interval polling interval, a file is stable when its size and
+	// modification time are unchanged for an interval
+	Interval time.Duration
+	// Poll poll src instead of filesystem notifications
+	Poll bool
+	// Archive folder the converted bills are moved into, empty keeps them in src
+	Archive string
+	// Errors folder the failed bills are moved into, empty keeps them in src
+	Errors string
+}
+
+// seen state of a file found in src
+type seen struct {
+	size    int64
+	modTime time.Time
+	// done converted or failed with this size and modification time
+	done bool
+}
+
+// Watch convert the bills as they land in src until ctx is done. Filesystem
+// notifications are used when available, otherwise src is polled.
+func Watch(ctx context.Context, src, destination string, opts Options, wopts WatchOptions) error {
+	if wopts.Interval <= 0 {
+		wopts.Interval = 2 * time.Second
+	}
+	if opts.Mode == ModeDated {
+		return fmt.Errorf("ERROR: watch: mode %s is not supported", ModeDated)
+	}
+	if err := os.MkdirAll(destination, 0777); err != nil {
+		return fmt.Errorf("ERROR: MkdirAll: %v", err)
+	}
+
+	// Do not convert the moved bills again, the folders are matched by path
+	// as an exclude pattern would match the same name at any depth of src
+	skip := []string{}
+	for _, dir := range []string{wopts.Archive, wopts.Errors} {
+		if dir == "" {
+			continue
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("ERROR: watch: %v", err)
+		}
+		skip = append(skip, abs)
+	}
+
+	var ledger *Ledger
+	if opts.Ledger != "" {
+		var err error
+		if ledger, err = OpenLedger(opts.Ledger); err != nil {
+			return fmt.Errorf("ERROR: OpenLedger: %v", err)
+		}
+	}
+
+	var db *output.DB
+	if opts.Database != "" {
+		var err error
+		if db, err = output.OpenDB(opts.Database); err != nil {
+			return fmt.Errorf("ERROR: OpenDB: %v", err)
+		}
+		defer db.Close()
+	}
+
+	// With notifications src is scanned only after a change or while files
+	// are waiting to be stable, polling scans on every interval
+	var watcher *fsnotify.Watcher
+	var events chan fsnotify.Event
+	if !wopts.Poll {
+		var err error
+		if watcher, err = newWatcher(src, opts.Recursive); err != nil {
+			fmt.Printf("WARN: watch: %v, fall back to polling\n", err)
+		} else {
+			defer watcher.Close()
+			events = watcher.Events
+			go func() {
+				for err := range watcher.Errors {
+					fmt.Printf("WARN: watch: %v\n", err)
+				}
+			}()
+		}
+	}
+
+	ticker := time.NewTicker(wopts.Interval)
+	defer ticker.Stop()
+
+	files := make(map[string]*seen)
+	dirty, pending := true, false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-events:
+			dirty = true
+			// Watch the new subfolders
+			if opts.Recursive && e.Op&fsnotify.Create != 0 {
+				if stat, err := os.Stat(e.Name); err == nil && stat.IsDir() {
+					if err := watcher.Add(e.Name); err != nil {
+						fmt.Printf("WARN: watch: %v\n", err)
+					}
+				}
+			}
+		case <-ticker.C:
+			if watcher == nil || dirty || pending {
+				dirty = false
+				pending = check(src, destination, opts, wopts, skip, ledger, db, files)
+			}
+		}
+	}
+}
+
+// check convert the stable files of src, true if some files are not stable yet
+func check(src, destination string, opts Options, wopts WatchOptions, skip []string, ledger *Ledger, db *output.DB, files map[string]*seen) bool {
+	candidates, err := scan(src, opts, skip, &Report{})
+	if err != nil {
+		fmt.Printf("WARN: watch: %v\n", err)
+		return true
+	}
+
+	var pending bool
+
+	found := make(map[string]bool)
+	for _, f := range candidates {
+		found[f] = true
+		stat, err := os.Stat(src + "/" + f)
+		if err != nil {
+			continue
+		}
+
+		s, ok := files[f]
+		if !ok || s.size != stat.Size() || !s.modTime.Equal(stat.ModTime()) {
+			// New or still being written, check again on next interval
+			files[f] = &seen{size: stat.Size(), modTime: stat.ModTime()}
+			pending = true
+			continue
+		}
+		if s.done {
+			continue
+		}
+		s.done = true
+
+		// An archive is moved to the errors folder if any of its bills failed
+		status := StatusSkipped
+		for _, result := range convert(f, src, destination, opts, ledger, db) {
+			if result.Status == StatusFailed || status == StatusSkipped {
+				status = result.Status
+			}
+		}
+		if status == StatusSkipped {
+			continue
+		}
+		dir := wopts.Archive
+		if status == StatusFailed {
+			dir = wopts.Errors
+		}
+		if dir != "" {
+			if err := move(src+"/"+f, dir+"/"+f); err != nil {
+				fmt.Printf("WARN: watch: move %s: %v\n", f, err)
+			}
+		}
+	}
+
+	// Forget the removed files
+	for f := range files {
+		if !found[f] {
+			delete(files, f)
+		}
+	}
+
+	return pending
+}
+
+// newWatcher watch src, and its subfolders when recursive
+func newWatcher(src string, recursive bool) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.Walk(src, func(p string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !f.IsDir() {
+			return nil
+		}
+		if p != src && !recursive {
+			return filepath.SkipDir
+		}
+		return watcher.Add(p)
+	})
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	return watcher, nil
+}
+
+// move rename the file, creating the folder
+func move(from, to string) error {
+	if err := os.MkdirAll(path.Dir(to), 0777); err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
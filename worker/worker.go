@@ -1,12 +1,17 @@
 package worker
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"os"
+	"path"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fengdu/billconverter/converter"
@@ -14,119 +19,578 @@ import (
 	"github.com/fengdu/billconverter/output"
 )
 
-// Start get files form src, then write csv to destination
-func Start(src, destination string) {
-	// Clear dst folder
-	if stat, err := os.Stat(destination); err == nil && stat.IsDir() {
-		temp := fmt.Sprintf("_%v", time.Now().Unix())
-		os.Rename(destination, temp)
-		os.RemoveAll(temp)
+// Positions output choices of Options.Positions
+const (
+	PositionsGathered = "gathered"
+	PositionsDetailed = "detailed"
+	PositionsBoth     = "both"
+)
+
+// Output modes of Options.Mode
+const (
+	// ModeFail fail the bill if an output file exists
+	ModeFail = "fail"
+	// ModeOverwrite overwrite the output files of the same name, other files are kept
+	ModeOverwrite = "overwrite"
+	// ModeDated write into a subfolder of dst named by the run date
+	ModeDated = "dated"
+)
+
+// Output formats of Options.Formats
+const (
+	// FormatCSV a csv file per output of the template
+	FormatCSV = "csv"
+	// FormatXLSX a workbook per bill with the balances, positions and trades sheets
+	FormatXLSX = "xlsx"
+	// FormatJSON a json document per bill with the header and the outputs
+	FormatJSON = "json"
+	// FormatNDJSON a json record per line for each row of the outputs
+	FormatNDJSON = "ndjson"
+	// FormatParquet a parquet file of the trades, positions and balances records,
+	// partitioned as kind/account=X/statement_date=yyyy-mm-dd under dst
+	FormatParquet = "parquet"
+)
+
+// Options options of a convert run
+type Options struct {
+	// Positions which open positions to write: gathered, detailed or both
+	Positions string
+	// Template output layout
+	Template converter.Template
+	// FailFast skip the remaining files after the first failed one
+	FailFast bool
+	// Mode how to treat existing files of dst: fail, overwrite or dated
+	Mode string
+	// Workers number of files converted concurrently, default is the number of CPUs
+	Workers int
+	// Recursive scan the subfolders of src
+	Recursive bool
+	// Include glob patterns of the bills, default is DefaultInclude. Archives
+	// are read whatever the patterns, which match the bills inside them.
+	Include []string
+	// Exclude glob patterns of the skipped files and folders
+	Exclude []string
+	// Mirror write the outputs into the same subfolder under dst as the bill under src
+	Mirror bool
+	// Ledger file of the converted bills, bills converted with the same content
+	// into the same outputs are skipped, as ledgerTargets. Empty disables the
+	// ledger.
+	Ledger string
+	// Force convert the bills in the ledger again
+	Force bool
+	// Encoding of the bills, default is input.EncodingAuto
+	Encoding string
+	// Formats output formats, default is FormatCSV
+	Formats []string
+	// Database SQLite file the statements are written into, empty disables it
+	Database string
+}
+
+// Start get files form src, then write csv to destination. Src is a folder or
+// an archive, the bills of the archives are converted individually. A failed file does
+// not stop the others unless FailFast, the report has the result of every file.
+// Files are converted by a pool of Options.Workers goroutines, when ctx is done
+// the queued files are skipped and the in-flight ones finish.
+func Start(ctx context.Context, src, destination string, opts Options) (*Report, error) {
+	if opts.Mode == ModeDated {
+		destination = destination + "/" + time.Now().Format("20060102")
 	}
 	if err := os.MkdirAll(destination, 0777); err != nil {
-		fmt.Println("ERROR: MkdirAll: ", err)
-		return
+		return nil, fmt.Errorf("ERROR: MkdirAll: %v", err)
 	}
 
-	// Read bills from src folder
-	files, err := ioutil.ReadDir(src)
-	if err != nil {
-		log.Fatalln("ERROR: ReadDir: ", err)
+	// Read bills from src folder, or src is an archive of bills
+	report := &Report{}
+	var files []string
+	var err error
+	if stat, e := os.Stat(src); e == nil && !stat.IsDir() && input.IsArchive(src) {
+		src, files = filepath.Dir(src), []string{filepath.Base(src)}
+	} else if files, err = scan(src, opts, nil, report); err != nil {
+		return nil, fmt.Errorf("ERROR: ReadDir: %v", err)
 	}
 
-	var waitGroup sync.WaitGroup
+	var ledger *Ledger
+	if opts.Ledger != "" {
+		if ledger, err = OpenLedger(opts.Ledger); err != nil {
+			return nil, fmt.Errorf("ERROR: OpenLedger: %v", err)
+		}
+	}
 
-	waitGroup.Add(len(files))
+	var db *output.DB
+	if opts.Database != "" {
+		if db, err = output.OpenDB(opts.Database); err != nil {
+			return nil, fmt.Errorf("ERROR: OpenDB: %v", err)
+		}
+		defer db.Close()
+	}
 
-	for _, f := range files {
-		// Convert to csv file individually
-		go func(f os.FileInfo) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	var failed int32
+	var waitGroup sync.WaitGroup
+	jobs := make(chan string)
+
+	for i := 0; i < workers; i++ {
+		waitGroup.Add(1)
+		go func() {
 			defer waitGroup.Done()
-			if !f.IsDir() && strings.HasSuffix(f.Name(), ".txt") {
-				_, err := process(f.Name(), src, destination)
-				if err != nil {
-					fmt.Println(err)
-					os.Exit(1)
+			// Convert to csv file individually
+			for f := range jobs {
+				for _, result := range convert(f, src, destination, opts, ledger, db) {
+					if result.Status == StatusFailed {
+						atomic.AddInt32(&failed, 1)
+					}
+					report.add(result)
 				}
-				fmt.Printf("INFO: %s convert successed.\n", f.Name())
 			}
+		}()
+	}
 
-		}(f)
+	for _, f := range files {
+		switch {
+		case opts.FailFast && atomic.LoadInt32(&failed) > 0:
+			report.add(Result{File: f, Status: StatusSkipped, Reason: "fail fast after a failed file"})
+		case ctx.Err() != nil:
+			report.add(Result{File: f, Status: StatusSkipped, Reason: "canceled"})
+		default:
+			select {
+			case jobs <- f:
+			case <-ctx.Done():
+				report.add(Result{File: f, Status: StatusSkipped, Reason: "canceled"})
+			}
+		}
 	}
 
+	close(jobs)
 	waitGroup.Wait()
-	fmt.Println("INFO: all file convert successed.")
+
+	return report, nil
+}
+
+// convert convert a bill, or each bill of an archive, unless the ledger has
+// the same content converted
+func convert(filename, src, destination string, opts Options, ledger *Ledger, db *output.DB) []Result {
+	return readBills(filename, src, opts, func(bl bill, b []byte) Result {
+		return convertBill(bl, b, src, destination, opts, ledger, db)
+	})
 }
 
-func process(filename, src, destination string) ([]string, error) {
-	content, err := input.RetriveBillContent(src + "/" + filename)
+// readBills call fn with the content of a bill, or of each bill of an archive
+func readBills(filename, src string, opts Options, fn func(bl bill, b []byte) Result) []Result {
+	if input.IsArchive(filename) {
+		return readArchive(filename, src, opts, fn)
+	}
+
+	b, err := ioutil.ReadFile(src + "/" + filename)
 	if err != nil {
-		return nil, fmt.Errorf("ERROR: read: %s", filename)
+		err = fmt.Errorf("ERROR: read: %s: %v", filename, err)
+		fmt.Println(err)
+		return []Result{{File: filename, Status: StatusFailed, Reason: err.Error()}}
+	}
+
+	dir := path.Dir(filename)
+	return []Result{fn(bill{name: filename, dir: dir, prefix: dirPrefix(dir, opts)}, b)}
+}
+
+// dirPrefix output name prefix of the bills of a subfolder of src, so bills
+// of the same account and date in two subfolders do not write the same files.
+// Mirror writes them into different folders instead.
+func dirPrefix(dir string, opts Options) string {
+	if opts.Mirror || dir == "." {
+		return ""
+	}
+	return sanitize(dir) + "_"
+}
+
+// sanitize a relative path as a part of a file name
+func sanitize(p string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, p)
+}
+
+// readArchive call fn with the bills of an archive matching the include
+// patterns, a bill is named by the archive and member paths
+func readArchive(filename, src string, opts Options, fn func(bl bill, b []byte) Result) []Result {
+	include := opts.Include
+	if len(include) == 0 {
+		include = []string{DefaultInclude}
 	}
 
-	// Get bill base info
-	bill, err := converter.GetBillBaseInfo(content)
+	results := []Result{}
+	err := input.ReadArchive(src+"/"+filename, func(member string, b []byte) error {
+		name := filename + "/" + member
+		switch {
+		case match(opts.Exclude, member):
+			results = append(results, Result{File: name, Status: StatusSkipped, Reason: "excluded"})
+		case !match(include, member):
+			results = append(results, Result{File: name, Status: StatusSkipped, Reason: "not matching include " + strings.Join(include, ",")})
+		default:
+			// The member path, as a.txt and b/a.txt are different bills
+			stem := strings.TrimSuffix(member, path.Ext(member))
+			dir := path.Dir(filename)
+			bl := bill{name: name, dir: dir, prefix: dirPrefix(dir, opts) + sanitize(stem) + "_"}
+			results = append(results, fn(bl, b))
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("ERROR: GetBillBaseInfo: %s: %v", filename, err)
+		err = fmt.Errorf("ERROR: read: %s: %v", filename, err)
+		fmt.Println(err)
+		results = append(results, Result{File: filename, Status: StatusFailed, Reason: err.Error()})
+	}
+	if len(results) == 0 {
+		results = append(results, Result{File: filename, Status: StatusSkipped, Reason: "no bill in archive"})
 	}
 
-	now := time.Now()
-	shortT := now.Format("20060102")
-	longT := now.Format("20060102150405")
+	return results
+}
 
-	// Convert segments to csv
-	filepaths := []string{}
+// bill a bill to convert
+type bill struct {
+	// name path relative to src, for an archive member the archive path joined
+	// with the member path
+	name string
+	// dir subfolder of src the bill is in, used by Options.Mirror
+	dir string
+	// prefix of the output file names, an archive member prefixes its name
+	// as the bills of an archive often have the same account and date, and
+	// the subfolder is prefixed unless Options.Mirror
+	prefix string
+}
+
+// convertBill convert the content of a bill unless the ledger has it converted
+func convertBill(bl bill, b []byte, src, destination string, opts Options, ledger *Ledger, db *output.DB) Result {
+	var key, hash, targets string
+	if ledger != nil {
+		key = ledgerKey(src, bl.name)
+		hash = hashContent(b)
+		targets = ledgerTargets(destination, opts)
+		if !opts.Force && ledger.Converted(key, hash, targets) {
+			return Result{File: bl.name, Status: StatusSkipped, Reason: "already converted"}
+		}
+	}
 
-	var fp string
-	fp, err = writeBalances(&content, destination, shortT, longT, bill)
+	outputs, encoding, err := process(bl, b, destination, opts, db)
 	if err != nil {
-		return nil, err
+		fmt.Println(err)
+		return Result{File: bl.name, Status: StatusFailed, Reason: err.Error(), Encoding: encoding}
 	}
-	filepaths = append(filepaths, fp)
 
-	fp, err = writePos(&content, destination, shortT, longT, bill)
+	if ledger != nil {
+		if err := ledger.Record(key, hash, targets, outputs); err != nil {
+			fmt.Printf("WARN: %s: ledger: %v\n", bl.name, err)
+		}
+	}
+	fmt.Printf("INFO: %s convert successed.\n", bl.name)
+
+	return Result{File: bl.name, Status: StatusSucceeded, Outputs: outputs, Encoding: encoding}
+}
+
+// parse decode the content of a bill and parse it into statement, the encoding
+// the bill is decoded with is returned even if the parse fails
+func parse(bl bill, b []byte, opts Options) (converter.Statement, string, error) {
+	encoding := opts.Encoding
+	if encoding == "" {
+		encoding = input.EncodingAuto
+	}
+	content, encoding, err := input.DecodeBill(b, encoding)
 	if err != nil {
-		return nil, err
+		return converter.Statement{}, encoding, fmt.Errorf("ERROR: read: %s: %v", bl.name, err)
 	}
-	filepaths = append(filepaths, fp)
 
-	fp, err = writeTrades(&content, destination, shortT, longT, bill)
+	st, err := converter.Parse(content)
+	if pe, ok := err.(*converter.ParseError); ok {
+		pe.File = bl.name
+		return st, encoding, fmt.Errorf("ERROR: Parse: %v", pe)
+	}
 	if err != nil {
-		return nil, err
+		return st, encoding, fmt.Errorf("ERROR: Parse: %s: %v", bl.name, err)
 	}
-	filepaths = append(filepaths, fp)
 
-	return filepaths, nil
+	return st, encoding, nil
 }
 
-func writeBalances(content *string, destination, shortT, longT string, bill converter.BillBaseInfo) (string, error) {
-	data := converter.GetBalances(bill, *content)
-	filename := fmt.Sprintf("%s_WANDA_SHBalances_%s_%s.csv", bill.AccountNo, shortT, longT)
-	filepath := destination + "/" + filename
-	if err := output.Write(filepath, data); err != nil {
-		return "", fmt.Errorf("ERROR: write: Balances: %s：%v", filename, err)
+// process convert the content of a bill, and write the statement into db if
+// not nil. The encoding the bill is decoded with is returned even if the
+// convert fails.
+func process(bl bill, b []byte, destination string, opts Options, db *output.DB) ([]string, string, error) {
+	filename := bl.name
+	st, encoding, err := parse(bl, b, opts)
+	if err != nil {
+		return nil, encoding, err
+	}
+	if err := converter.CheckJournal(st); err != nil {
+		fmt.Printf("WARN: %s: %v\n", filename, err)
 	}
 
-	return filepath, nil
+	if opts.Mirror {
+		destination = path.Join(destination, bl.dir)
+		if err := os.MkdirAll(destination, 0777); err != nil {
+			return nil, encoding, fmt.Errorf("ERROR: MkdirAll: %s: %v", filename, err)
+		}
+	}
+
+	// The files of the bill are written together by the batch, a failed
+	// output leaves none of them
+	batch := &output.Batch{Overwrite: opts.Mode == ModeOverwrite}
+	defer batch.Abort()
+
+	now := time.Now()
+	formats := opts.Formats
+	if len(formats) == 0 {
+		formats = []string{FormatCSV}
+	}
+
+	filepaths := []string{}
+	for _, format := range formats {
+		switch format {
+		case FormatCSV:
+			// Convert segments to csv
+			for _, o := range outputs(opts) {
+				fp, err := writeOutput(st, o, destination, bl.prefix, batch, now)
+				if err != nil {
+					return nil, encoding, err
+				}
+				filepaths = append(filepaths, fp)
+			}
+		case FormatXLSX, FormatJSON, FormatNDJSON:
+			fp, err := writeDocument(st, outputs(opts), destination, bl.prefix, format, batch)
+			if err != nil {
+				return nil, encoding, err
+			}
+			filepaths = append(filepaths, fp)
+		case FormatParquet:
+			fps, err := writeParquet(st, destination, bl.prefix, batch)
+			if err != nil {
+				return nil, encoding, err
+			}
+			filepaths = append(filepaths, fps...)
+		default:
+			return nil, encoding, fmt.Errorf("ERROR: unknown format: %s", format)
+		}
+	}
+
+	if err := batch.Commit(); err != nil {
+		return nil, encoding, fmt.Errorf("ERROR: write: %v", err)
+	}
+
+	if db != nil {
+		if err := db.WriteStatement(dbStatement(st)); err != nil {
+			return nil, encoding, fmt.Errorf("ERROR: write: database: %s: %v", filename, err)
+		}
+	}
+
+	return filepaths, encoding, nil
+}
+
+// dbStatement the statement with the balances, positions, trades and journal tables
+func dbStatement(st converter.Statement) output.Statement {
+	result := output.Statement{
+		Account:            st.Header.AccountNo,
+		StatementDate:      st.Header.StatementDateEnd,
+		StatementDateStart: st.Header.StatementDateStart,
+		BillDate:           st.Header.BillDate,
+	}
+	for _, kind := range []string{converter.KindBalances, converter.KindPositions, converter.KindTrades, converter.KindJournal} {
+		t := output.Table{Name: kind, Columns: converter.Fields(kind)}
+		for _, f := range t.Columns {
+			t.Types = append(t.Types, sqlType(f))
+		}
+		for _, r := range converter.Records(kind, st) {
+			t.Rows = append(t.Rows, r.Values(t.Columns))
+		}
+		result.Tables = append(result.Tables, t)
+	}
+	return result
+}
+
+// sqlType declared SQLite type of a field, dates and times are text as
+// output.sqlValue
+func sqlType(field string) string {
+	switch converter.FieldType(field) {
+	case converter.TypeInt, converter.TypeBool:
+		return output.SQLInteger
+	case converter.TypeAmount, converter.TypePrice:
+		return output.SQLNumeric
+	}
+	return output.SQLText
+}
+
+// template Options.Template, or the default template if it has no outputs
+func template(opts Options) converter.Template {
+	if len(opts.Template.Outputs) == 0 {
+		return converter.DefaultTemplate()
+	}
+	return opts.Template
+}
+
+// outputs the outputs of the template filtered by Options.Positions
+func outputs(opts Options) []converter.Output {
+	result := []converter.Output{}
+	for _, o := range template(opts).Outputs {
+		if o.Kind == converter.KindPositions && opts.Positions == PositionsDetailed {
+			continue
+		}
+		if o.Kind == converter.KindOpenLots && opts.Positions != PositionsDetailed && opts.Positions != PositionsBoth {
+			continue
+		}
+		result = append(result, o)
+	}
+	return result
 }
 
-func writePos(content *string, destination, shortT, longT string, bill converter.BillBaseInfo) (string, error) {
-	data := converter.GetPos(bill, *content)
-	filename := fmt.Sprintf("%s_WANDA_SHPos_%s_%s.csv", bill.AccountNo, shortT, longT)
+func writeOutput(st converter.Statement, o converter.Output, destination, prefix string, batch *output.Batch, now time.Time) (string, error) {
+	data := o.Rows(st)
+	filename := prefix + o.FileName(st.Header, now)
 	filepath := destination + "/" + filename
-	if err := output.Write(filepath, data); err != nil {
-		return "", fmt.Errorf("ERROR: write: Pos: %s：%v", filename, err)
+	err := batch.WriteFile(filepath, func(w io.Writer) error {
+		return output.WriteCSV(w, data)
+	})
+	if err != nil {
+		return "", fmt.Errorf("ERROR: write: %s: %s：%v", o.Name, filename, err)
 	}
 
 	return filepath, nil
 }
 
-func writeTrades(content *string, destination, shortT, longT string, bill converter.BillBaseInfo) (string, error) {
-	data := converter.GetTrades(bill, *content)
-	filename := fmt.Sprintf("%s_WANDA_SHTrades_%s_%s.csv", bill.AccountNo, shortT, longT)
+// section the typed rows of an output, a json or ndjson record is keyed by
+// the field names rather than the headers of the columns and has a repeated
+// field once
+func section(st converter.Statement, o converter.Output, format string) output.Section {
+	rows := o.Values(st)
+	if format == FormatXLSX {
+		return output.Section{Name: o.Name, Rows: rows}
+	}
+
+	keys := o.Keys()
+	result := [][]interface{}{}
+	for i, row := range rows {
+		record := []interface{}{}
+		for j, k := range keys {
+			if k == "" {
+				continue
+			}
+			if i == 0 {
+				record = append(record, k)
+			} else {
+				record = append(record, row[j])
+			}
+		}
+		result = append(result, record)
+	}
+	return output.Section{Name: o.Name, Rows: result}
+}
+
+// writeDocument write the outputs in a json, ndjson or xlsx file named by the
+// account and statement date. A workbook only has the balances, positions and
+// trades sheets.
+func writeDocument(st converter.Statement, outs []converter.Output, destination, prefix, format string, batch *output.Batch) (string, error) {
+	sections := []output.Section{}
+	for _, o := range outs {
+		switch o.Kind {
+		case converter.KindBalances, converter.KindPositions, converter.KindOpenLots, converter.KindTrades:
+		default:
+			if format == FormatXLSX {
+				continue
+			}
+		}
+		sections = append(sections, section(st, o, format))
+	}
+
+	filename := fmt.Sprintf("%s%s_%s.%s", prefix, st.Header.AccountNo, st.Header.StatementDateEnd.Format("20060102"), format)
 	filepath := destination + "/" + filename
-	if err := output.Write(filepath, data); err != nil {
-		return "", fmt.Errorf("ERROR: write: Trades: %s：%v", filename, err)
+	err := batch.WriteFile(filepath, func(w io.Writer) error {
+		switch format {
+		case FormatXLSX:
+			return output.WriteXLSX(w, sections)
+		case FormatJSON:
+			return output.WriteJSON(w, headerFields(st.Header), sections)
+		}
+		return output.WriteNDJSON(w, sections)
+	})
+	if err != nil {
+		return "", fmt.Errorf("ERROR: write: %s: %s：%v", format, filename, err)
 	}
 
 	return filepath, nil
 }
+
+// headerFields the header of a json document
+func headerFields(h converter.BillBaseInfo) []output.Field {
+	return []output.Field{
+		{Name: "account", Value: h.AccountNo},
+		{Name: "statement_date_start", Value: h.StatementDateStart},
+		{Name: "statement_date_end", Value: h.StatementDateEnd},
+		{Name: "bill_date", Value: h.BillDate},
+	}
+}
+
+// writeParquet write the trades, positions and balances records in the
+// partition of the account and statement date. The partition columns are not
+// in the files.
+func writeParquet(st converter.Statement, destination, prefix string, batch *output.Batch) ([]string, error) {
+	partition := fmt.Sprintf("account=%s/statement_date=%s", st.Header.AccountNo, st.Header.StatementDateEnd.Format("2006-01-02"))
+
+	filepaths := []string{}
+	for _, kind := range []string{converter.KindTrades, converter.KindPositions, converter.KindBalances} {
+		fields := []string{}
+		columns := []output.ParquetColumn{}
+		for _, f := range converter.Fields(kind) {
+			if f == "account" || f == "statement_date" {
+				continue
+			}
+			fields = append(fields, f)
+			columns = append(columns, parquetColumn(f))
+		}
+
+		rows := [][]interface{}{}
+		for _, r := range converter.Records(kind, st) {
+			rows = append(rows, r.Values(fields))
+		}
+
+		dir := path.Join(destination, kind, partition)
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return nil, fmt.Errorf("ERROR: MkdirAll: %v", err)
+		}
+		filepath := dir + "/" + prefix + "data.parquet"
+		err := batch.WriteFile(filepath, func(w io.Writer) error {
+			return output.WriteParquet(w, columns, rows)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("ERROR: write: parquet: %s/%s：%v", kind, partition, err)
+		}
+		filepaths = append(filepaths, filepath)
+	}
+
+	return filepaths, nil
+}
+
+// parquetColumn typed column of a field, amounts have 2 decimals and prices
+// the 7 of the bills
+func parquetColumn(field string) output.ParquetColumn {
+	c := output.ParquetColumn{Name: field}
+	switch converter.FieldType(field) {
+	case converter.TypeInt:
+		c.Type = output.ParquetInt
+	case converter.TypeBool:
+		c.Type = output.ParquetBool
+	case converter.TypeAmount:
+		c.Type, c.Scale = output.ParquetDecimal, 2
+	case converter.TypePrice:
+		c.Type, c.Scale = output.ParquetDecimal, 7
+	case converter.TypeDate:
+		c.Type = output.ParquetDate
+	case converter.TypeTime:
+		c.Type = output.ParquetTimestamp
+	default:
+		c.Type = output.ParquetString
+	}
+	return c
+}
@@ -1,13 +1,28 @@
 package worker
 
 import (
-	"fmt"
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
 	"os"
+	"path"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/fengdu/billconverter/converter"
+	"github.com/fengdu/billconverter/input"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xuri/excelize/v2"
 	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
 	"golang.org/x/text/transform"
 )
 
@@ -157,39 +172,726 @@ func init() {
 }
 
 func TestProcess(t *testing.T) {
-	temp := fmt.Sprintf("./_test_%v", time.Now().Unix())
-	src := temp + "/src"
-	destination := temp + "/dst"
+	_, src, destination := testDirs(t)
 
 	srcFilename := "_test.txt"
 	srcFilepath := src + "/" + srcFilename
 
-	os.Mkdir(temp, 0777)
-	defer os.RemoveAll(temp)
-	os.Mkdir(src, 0777)
-	os.Mkdir(destination, 0777)
-
-	f, err := os.Create(srcFilepath)
-	if err != nil {
+	if err := writeBill(srcFilepath, content); err != nil {
 		t.Error(err)
 		return
 	}
+
+	s, _, _ := processFile(srcFilename, src, destination, Options{Positions: PositionsBoth, Template: converter.DefaultTemplate()})
+
+	if len(s) != 7 {
+		t.Errorf("Expected 7 files has been generated, but get %v", len(s))
+	}
+
+	for _, fp := range s {
+		if !strings.Contains(fp, "61188803") {
+			t.Errorf("Expected created file name contains account no: 61188803, but not: %s", fp)
+		}
+	}
+}
+
+func TestProcessEncoding(t *testing.T) {
+	temp, src, _ := testDirs(t)
+
+	utf16le, _ := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().String(content)
+	utf16be, _ := unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewEncoder().String(content)
+	bills := map[string]struct {
+		content  string
+		encoding string
+	}{
+		"utf8.txt":    {content, input.EncodingUTF8},
+		"utf8bom.txt": {"\xEF\xBB\xBF" + content, input.EncodingUTF8},
+		"utf16le.txt": {utf16le, input.EncodingUTF16LE},
+		"utf16be.txt": {utf16be, input.EncodingUTF16BE},
+	}
+
+	for name, bill := range bills {
+		ioutil.WriteFile(src+"/"+name, []byte(bill.content), 0644)
+		os.MkdirAll(temp+"/"+name, 0777)
+		_, encoding, err := processFile(name, src, temp+"/"+name, Options{})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if encoding != bill.encoding {
+			t.Errorf("%s: expected encoding %s, but got %s", name, bill.encoding, encoding)
+		}
+	}
+
+	// Overriding with a wrong encoding fails to find the account
+	if _, _, err := processFile("utf8.txt", src, temp+"/gbk", Options{Encoding: input.EncodingGBK}); err == nil {
+		t.Errorf("Expected utf-8 bill decoded as gbk failed, but not")
+	}
+}
+
+func TestStart(t *testing.T) {
+	_, src, destination := testDirs(t)
+
+	os.MkdirAll(src+"/sub", 0777)
+
+	writeBill(src+"/good.txt", content)
+	writeBill(src+"/bad.txt", strings.Replace(content, "Account No", "Account", 1))
+	writeBill(src+"/readme.md", "")
+
+	report, err := Start(context.Background(), src, destination, Options{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := report.Count(StatusSucceeded); n != 1 {
+		t.Errorf("Expected 1 succeeded, but got %v", n)
+	}
+	if n := report.Count(StatusFailed); n != 1 {
+		t.Errorf("Expected 1 failed, but got %v", n)
+	}
+	if n := report.Count(StatusSkipped); n != 2 {
+		t.Errorf("Expected 2 skipped, but got %v", n)
+	}
+	if !report.Failed() {
+		t.Errorf("Expected report failed, but not")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err = Start(ctx, src, destination, Options{Mode: ModeOverwrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := report.Count(StatusSkipped); n != 4 {
+		t.Errorf("Expected all 4 files skipped when canceled, but got %v", n)
+	}
+}
+
+func TestOutputModes(t *testing.T) {
+	_, src, destination := testDirs(t)
+
+	writeBill(src+"/_test.txt", content)
+	ioutil.WriteFile(destination+"/other.csv", []byte("other job"), 0666)
+
+	tpl, _ := converter.ParseTemplate([]byte(`{"outputs": [{"kind": "trades", "name": "Trades", "filename": "{account}_Trades.csv", "columns": [{"header": "Account", "field": "account"}]}]}`))
+	opts := Options{Template: tpl, Mode: ModeFail}
+
+	if _, _, err := processFile("_test.txt", src, destination, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := processFile("_test.txt", src, destination, opts); err == nil {
+		t.Errorf("Expected file exists error in fail mode, but not")
+	}
+
+	opts.Mode = ModeOverwrite
+	if _, _, err := processFile("_test.txt", src, destination, opts); err != nil {
+		t.Errorf("Expected overwrite the file in overwrite mode, but got %v", err)
+	}
+
+	opts.Mode = ModeDated
+	report, err := Start(context.Background(), src, destination, opts)
+	if err != nil || report.Failed() {
+		t.Fatalf("Expected dated mode succeeded, but got %v %v", err, report.Results)
+	}
+	dated := destination + "/" + time.Now().Format("20060102") + "/61188803_Trades.csv"
+	if _, err := os.Stat(dated); err != nil {
+		t.Errorf("Expected %s created, but got %v", dated, err)
+	}
+
+	if b, _ := ioutil.ReadFile(destination + "/other.csv"); string(b) != "other job" {
+		t.Errorf("Expected other files of dst kept, but not")
+	}
+
+	// A bill whose second output exists in fail mode leaves none of its files
+	tpl, _ = converter.ParseTemplate([]byte(`{"outputs": [` +
+		`{"kind": "balances", "name": "Balances", "filename": "{account}_Balances.csv", "columns": [{"header": "Account", "field": "account"}]},` +
+		`{"kind": "trades", "name": "Trades", "filename": "{account}_Trades.csv", "columns": [{"header": "Account", "field": "account"}]}]}`))
+	opts = Options{Template: tpl, Mode: ModeFail}
+	if _, _, err := processFile("_test.txt", src, destination, opts); err == nil || !strings.Contains(err.Error(), "file exists") {
+		t.Errorf("Expected file exists error, but got %v", err)
+	}
+	files, _ := ioutil.ReadDir(destination)
+	for _, f := range files {
+		if f.Name() == "61188803_Balances.csv" || strings.HasPrefix(f.Name(), ".") {
+			t.Errorf("Expected no file of the failed bill, but got %s", f.Name())
+		}
+	}
+	if b, _ := ioutil.ReadFile(destination + "/61188803_Trades.csv"); !strings.HasPrefix(string(b), "Account") {
+		t.Errorf("Expected the existing trades kept, but got %q", b)
+	}
+}
+
+func TestStartRecursive(t *testing.T) {
+	_, src, destination := testDirs(t)
+
+	os.MkdirAll(src+"/20180301", 0777)
+	os.MkdirAll(src+"/archive", 0777)
+
+	writeBill(src+"/a.txt", content)
+	writeBill(src+"/20180301/b.txt", content)
+	writeBill(src+"/20180301/skip.txt", content)
+	writeBill(src+"/archive/c.txt", content)
+
+	opts := Options{Recursive: true, Exclude: []string{"skip*", "archive"}, Mirror: true, Mode: ModeOverwrite}
+	report, err := Start(context.Background(), src, destination, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := report.Count(StatusSucceeded); n != 2 {
+		t.Errorf("Expected 2 succeeded, but got %v", n)
+	}
+	if n := report.Count(StatusSkipped); n != 2 {
+		t.Errorf("Expected skip.txt and archive skipped, but got %v", n)
+	}
+
+	for _, r := range report.Results {
+		if r.File == "20180301/b.txt" && !strings.Contains(r.Outputs[0], "/dst/20180301/") {
+			t.Errorf("Expected outputs of 20180301/b.txt under dst/20180301, but got %v", r.Outputs[0])
+		}
+	}
+
+	// Without mirror the bills of the same account and date in two folders
+	// are prefixed by their folder
+	opts = Options{Recursive: true, Exclude: []string{"skip*", "archive"}, Mode: ModeFail}
+	report, err = Start(context.Background(), src, destination+"_flat", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := report.Count(StatusSucceeded); n != 2 {
+		t.Errorf("Expected 2 succeeded without mirror, but got %v", report.Results)
+	}
+	for _, r := range report.Results {
+		if r.File == "20180301/b.txt" && len(r.Outputs) > 0 && !strings.Contains(r.Outputs[0], "/dst_flat/20180301_61188803_") {
+			t.Errorf("Expected outputs of 20180301/b.txt prefixed by 20180301_, but got %v", r.Outputs[0])
+		}
+	}
+}
+
+func TestStartLedger(t *testing.T) {
+	temp, src, destination := testDirs(t)
+
+	writeBill(src+"/a.txt", content)
+	opts := Options{Ledger: temp + "/ledger.json", Mode: ModeOverwrite}
+
+	run := func(status string) {
+		report, err := Start(context.Background(), src, destination, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Results[0].Status != status {
+			t.Errorf("Expected a.txt %s, but got %v", status, report.Results[0])
+		}
+	}
+
+	run(StatusSucceeded)
+	run(StatusSkipped)
+
+	writeBill(src+"/a.txt", strings.Replace(content, "1706.0000000", "1707.0000000", 1))
+	run(StatusSucceeded)
+
+	// A bill converted without the database is converted again into it
+	opts.Database = temp + "/bills.db"
+	run(StatusSucceeded)
+	run(StatusSkipped)
+
+	// And into another dst
+	destination = temp + "/dst2"
+	run(StatusSucceeded)
+	if files, _ := ioutil.ReadDir(destination); len(files) == 0 {
+		t.Errorf("Expected outputs written into the new dst, but got none")
+	}
+	run(StatusSkipped)
+	opts.Positions = PositionsBoth
+	run(StatusSucceeded)
+
+	opts.Force = true
+	run(StatusSucceeded)
+}
+
+func TestProcessXLSX(t *testing.T) {
+	_, src, destination := testDirs(t)
+
+	writeBill(src+"/a.txt", content)
+	s, _, err := processFile("a.txt", src, destination, Options{Formats: []string{FormatXLSX}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s) != 1 || !strings.HasSuffix(s[0], "/61188803_20171212.xlsx") {
+		t.Fatalf("Expected workbook 61188803_20171212.xlsx, but got %v", s)
+	}
+
+	f, err := excelize.OpenFile(s[0])
+	if err != nil {
+		t.Fatal(err)
+	}
 	defer f.Close()
 
-	ts := simplifiedchinese.GBK.NewEncoder()
-	w := transform.NewWriter(f, ts)
-	w.Write([]byte(content))
-	defer w.Close()
+	if sheets := f.GetSheetList(); strings.Join(sheets, ",") != "Balances,Pos,Trades" {
+		t.Errorf("Expected sheets Balances,Pos,Trades, but got %v", sheets)
+	}
+	// Codes are text, amounts are numbers which have no cell type attribute
+	if typ, _ := f.GetCellType("Trades", "A2"); typ != excelize.CellTypeSharedString {
+		t.Errorf("Expected account text cell, but got %v", typ)
+	}
+	if typ, _ := f.GetCellType("Balances", "C2"); typ != excelize.CellTypeNumber && typ != excelize.CellTypeUnset {
+		t.Errorf("Expected balance number cell, but got %v", typ)
+	}
+}
+
+func TestProcessJSON(t *testing.T) {
+	_, src, destination := testDirs(t)
+
+	writeBill(src+"/a.txt", content)
+	s, _, err := processFile("a.txt", src, destination, Options{Formats: []string{FormatJSON, FormatNDJSON}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s) != 2 {
+		t.Fatalf("Expected json and ndjson files, but got %v", s)
+	}
+
+	b, _ := ioutil.ReadFile(s[0])
+	var doc struct {
+		Header map[string]string
+		Trades []map[string]interface{}
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Header["account"] != "61188803" || doc.Header["statement_date_end"] != "2017-12-12" {
+		t.Errorf("Expected header of account 61188803, but got %v", doc.Header)
+	}
+	if len(doc.Trades) == 0 {
+		t.Fatalf("Expected trades, but got none")
+	}
+	if _, ok := doc.Trades[0]["price"].(float64); !ok {
+		t.Errorf("Expected trade price a number, but got %#v", doc.Trades[0]["price"])
+	}
+	// Keyed by field names, a future has no strike price
+	if v, ok := doc.Trades[0]["strike_price"]; !ok || v != nil {
+		t.Errorf("Expected null strike price, but got %#v", doc.Trades[0])
+	}
+	if v := doc.Trades[0]["trade_date"]; v != "2017-12-12" {
+		t.Errorf("Expected trade_date 2017-12-12, but got %#v", v)
+	}
+	if _, ok := doc.Trades[0]["as-of-date (mm/dd/yyyy)"]; ok {
+		t.Errorf("Expected the repeated statement_date once, but got %#v", doc.Trades[0])
+	}
+
+	b, _ = ioutil.ReadFile(s[1])
+	types := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var r map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		types[r["record_type"].(string)]++
+	}
+	if types["Trades"] != len(doc.Trades) {
+		t.Errorf("Expected %d trades records, but got %d", len(doc.Trades), types["Trades"])
+	}
+}
 
-	s, _ := process(srcFilename, src, destination)
+func TestProcessParquet(t *testing.T) {
+	temp, src, destination := testDirs(t)
 
+	writeBill(src+"/a.txt", content)
+	s, _, err := processFile("a.txt", src, destination, Options{Formats: []string{FormatParquet}})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(s) != 3 {
-		t.Errorf("Expected 3 files has been generated, but get %v", len(s))
+		t.Fatalf("Expected trades, positions and balances parquet files, but got %v", s)
+	}
+	for i, kind := range []string{"trades", "positions", "balances"} {
+		expected := destination + "/" + kind + "/account=61188803/statement_date=2017-12-12/data.parquet"
+		if s[i] != path.Clean(expected) {
+			t.Errorf("Expected %s, but got %s", expected, s[i])
+		}
 	}
 
-	if !strings.Contains(s[0], "61188803") ||
-		!strings.Contains(s[1], "61188803") ||
-		!strings.Contains(s[2], "61188803") {
-		t.Errorf("Expected created file name contains account no: 61188803, but not")
+	// A price beyond the 7 decimals fails rather than rounded
+	writeBill(src+"/b.txt", strings.Replace(content, "1706.0000000|", "1706.00000001|", 1))
+	if _, _, err := processFile("b.txt", src, temp, Options{Formats: []string{FormatParquet}}); err == nil || !strings.Contains(err.Error(), "more than 7 decimals") {
+		t.Errorf("Expected price decimals error, but got %v", err)
 	}
+
+	// Read the first trade back by the schema of the file
+	fr, err := local.NewLocalFileReader(s[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+	pr, err := reader.NewParquetColumnReader(fr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	if n := pr.GetNumRows(); n != 7 {
+		t.Errorf("Expected 7 trades, but got %v", n)
+	}
+
+	read := func(field string, converted parquet.ConvertedType) interface{} {
+		for i, e := range pr.SchemaHandler.SchemaElements[1:] {
+			if !strings.EqualFold(e.Name, field) {
+				continue
+			}
+			if e.ConvertedType == nil || *e.ConvertedType != converted {
+				t.Errorf("Expected %s %v, but got %v", field, converted, e.ConvertedType)
+			}
+			values, _, _, err := pr.ReadColumnByIndex(int64(i), 1)
+			if err != nil || len(values) != 1 {
+				t.Fatalf("Expected a %s value, but got %v %v", field, values, err)
+			}
+			return values[0]
+		}
+		t.Fatalf("Expected column %s, but got none", field)
+		return nil
+	}
+
+	if v := read("price", parquet.ConvertedType_DECIMAL); v != int64(17060000000) {
+		t.Errorf("Expected price 1706.0000000, but got %v", v)
+	}
+	day := time.Date(2017, 12, 12, 0, 0, 0, 0, time.UTC).Unix() / 86400
+	if v := read("trade_date", parquet.ConvertedType_DATE); v != int32(day) {
+		t.Errorf("Expected trade_date %v, but got %v", day, v)
+	}
+	// 09:30:15 of the bill is UTC+8
+	ms := time.Date(2017, 12, 12, 1, 30, 15, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	if v := read("trade_time", parquet.ConvertedType_TIMESTAMP_MILLIS); v != ms {
+		t.Errorf("Expected trade_time %v, but got %v", ms, v)
+	}
+}
+
+func TestStartDatabase(t *testing.T) {
+	temp, src, destination := testDirs(t)
+
+	writeBill(src+"/a.txt", content)
+	opts := Options{Mode: ModeOverwrite, Database: temp + "/bills.db"}
+
+	count := func(table string) int {
+		db, err := sql.Open("sqlite3", opts.Database)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		var n int
+		if err := db.QueryRow("SELECT count(*) FROM " + table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	for i := 0; i < 2; i++ {
+		if report, err := Start(context.Background(), src, destination, opts); err != nil || report.Failed() {
+			t.Fatalf("Expected succeeded, but got %v %v", err, report)
+		}
+	}
+
+	if n := count("statements"); n != 1 {
+		t.Errorf("Expected 1 statement after rerun, but got %v", n)
+	}
+	if n := count("trades"); n != 7 {
+		t.Errorf("Expected 7 trades after rerun, but got %v", n)
+	}
+	if n := count("balances"); n == 0 {
+		t.Errorf("Expected balances, but got none")
+	}
+
+	// A corrected bill replaces the rows of the statement
+	writeBill(src+"/a.txt", strings.Replace(content, "1706.0000000", "1707.0000000", 1))
+	if _, err := Start(context.Background(), src, destination, opts); err != nil {
+		t.Fatal(err)
+	}
+	db, _ := sql.Open("sqlite3", opts.Database)
+	defer db.Close()
+	var price, typ string
+	db.QueryRow("SELECT price, typeof(price) FROM trades WHERE account = '61188803' AND statement_date = '2017-12-12' AND seq = 0").Scan(&price, &typ)
+	if price != "1707" || typ != "integer" {
+		t.Errorf("Expected upserted price 1707 integer, but got %v %v", price, typ)
+	}
+
+	// Amounts and prices compare as numbers
+	var n int
+	db.QueryRow("SELECT count(*) FROM trades WHERE price > 1703.5 AND price < 100000").Scan(&n)
+	if n != 5 {
+		t.Errorf("Expected 5 trades priced above 1703.5, but got %v", n)
+	}
+	var commission float64
+	db.QueryRow("SELECT commission FROM trades ORDER BY commission DESC LIMIT 1").Scan(&commission)
+	if commission != 17 {
+		t.Errorf("Expected the highest commission 17, but got %v", commission)
+	}
+	if n := count("trades"); n != 7 {
+		t.Errorf("Expected 7 trades after correction, but got %v", n)
+	}
+}
+
+func TestStartDatabaseMigrate(t *testing.T) {
+	temp, src, destination := testDirs(t)
+
+	writeBill(src+"/a.txt", content)
+	opts := Options{Mode: ModeOverwrite, Database: temp + "/bills.db"}
+
+	// A trades table of an earlier version, untyped and without price
+	db, err := sql.Open("sqlite3", opts.Database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE trades (seq, account, statement_date, PRIMARY KEY (account, statement_date, seq))"); err != nil {
+		t.Fatal(err)
+	}
+
+	if report, err := Start(context.Background(), src, destination, opts); err != nil || report.Failed() {
+		t.Fatalf("Expected succeeded, but got %v %v", err, report)
+	}
+
+	var price string
+	if err := db.QueryRow("SELECT price FROM trades WHERE account = '61188803' AND seq = 0").Scan(&price); err != nil {
+		t.Fatal(err)
+	}
+	if price != "1706" {
+		t.Errorf("Expected price 1706 in the added column, but got %v", price)
+	}
+
+	// A new table declares the types of its columns
+	var typ string
+	if err := db.QueryRow("SELECT typeof(base) FROM balances LIMIT 1").Scan(&typ); err != nil {
+		t.Fatal(err)
+	}
+	if typ != "integer" {
+		t.Errorf("Expected integer base, but got %v", typ)
+	}
+}
+
+func TestStartArchive(t *testing.T) {
+	temp, src, destination := testDirs(t)
+
+	data, _ := simplifiedchinese.GBK.NewEncoder().String(content)
+
+	// A zip of daily bills of the same account, with the same member name in
+	// two folders
+	zf, _ := os.Create(src + "/monthly.zip")
+	zw := zip.NewWriter(zf)
+	for _, name := range []string{"2018/day1.txt", "2019/day1.txt", "readme.md"} {
+		w, _ := zw.Create(name)
+		w.Write([]byte(data))
+	}
+	zw.Close()
+	zf.Close()
+
+	gf, _ := os.Create(src + "/day3.txt.gz")
+	gw := gzip.NewWriter(gf)
+	gw.Write([]byte(data))
+	gw.Close()
+	gf.Close()
+
+	tf, _ := os.Create(src + "/daily.tar.gz")
+	tgw := gzip.NewWriter(tf)
+	tw := tar.NewWriter(tgw)
+	tw.WriteHeader(&tar.Header{Name: "./day4.txt", Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+	tw.Write([]byte(data))
+	tw.Close()
+	tgw.Close()
+	tf.Close()
+
+	report, err := Start(context.Background(), src, destination, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := report.Count(StatusSucceeded); n != 4 {
+		t.Errorf("Expected 4 bills succeeded, but got %v: %v", n, report.Results)
+	}
+	if n := report.Count(StatusSkipped); n != 1 {
+		t.Errorf("Expected readme.md skipped, but got %v", n)
+	}
+
+	files, _ := ioutil.ReadDir(destination)
+	if len(files) != 4*6 {
+		t.Errorf("Expected %d outputs, but got %d", 4*6, len(files))
+	}
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), "day") && !strings.HasPrefix(f.Name(), "2018_day1_") && !strings.HasPrefix(f.Name(), "2019_day1_") {
+			t.Errorf("Expected output named after the archive member path, but got %s", f.Name())
+		}
+	}
+
+	// Src is the archive itself
+	report, err = Start(context.Background(), src+"/monthly.zip", temp+"/dst_zip", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := report.Count(StatusSucceeded); n != 2 {
+		t.Errorf("Expected 2 bills succeeded, but got %v: %v", n, report.Results)
+	}
+	if report.Results[0].File != "monthly.zip/2018/day1.txt" {
+		t.Errorf("Expected bill named monthly.zip/2018/day1.txt, but got %s", report.Results[0].File)
+	}
+
+	// The archives are read whatever the include patterns, which select the
+	// bills inside them
+	report, err = Start(context.Background(), src, temp+"/dst_include", Options{Include: []string{"2019/*.txt"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	succeeded := []string{}
+	for _, r := range report.Results {
+		if r.Status == StatusSucceeded {
+			succeeded = append(succeeded, r.File)
+		}
+	}
+	if strings.Join(succeeded, ",") != "monthly.zip/2019/day1.txt" {
+		t.Errorf("Expected only monthly.zip/2019/day1.txt succeeded, but got %v", report.Results)
+	}
+}
+
+func TestStream(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Stream(strings.NewReader(content), &buf, "Trades", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !strings.HasPrefix(lines[0], "Account,") || len(lines) < 2 {
+		t.Errorf("Expected trades csv with header, but got %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := Stream(strings.NewReader(content), &buf, "", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	var trades []map[string]interface{}
+	json.Unmarshal(doc["Trades"], &trades)
+	if len(doc) != 7 || len(trades) != len(lines)-1 {
+		t.Errorf("Expected header, 6 sections and %d trades, but got %d keys and %d trades", len(lines)-1, len(doc), len(trades))
+	}
+	if trades[0]["account"] != "61188803" {
+		t.Errorf("Expected trade of account 61188803, but got %v", trades[0])
+	}
+
+	if err := Stream(strings.NewReader(content), &buf, "unknown", Options{}); err == nil {
+		t.Errorf("Expected unknown segment failed, but not")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	for _, poll := range []bool{true, false} {
+		_, src, destination := testDirs(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			wopts := WatchOptions{Interval: 20 * time.Millisecond, Poll: poll, Archive: src + "/archive", Errors: src + "/errors"}
+			done <- Watch(ctx, src, destination, Options{Recursive: true}, wopts)
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		writeBill(src+"/a.txt", content)
+		writeBill(src+"/b.txt", "broken bill")
+		// A folder of the same name as the archive folder is still watched
+		os.MkdirAll(src+"/day/archive", 0777)
+		writeBill(src+"/day/archive/c.txt", content)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			_, errA := os.Stat(src + "/archive/a.txt")
+			_, errB := os.Stat(src + "/errors/b.txt")
+			_, errC := os.Stat(src + "/archive/day/archive/c.txt")
+			if errA == nil && errB == nil && errC == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(src + "/archive/a.txt"); err != nil {
+			t.Errorf("poll %v: expected a.txt archived, but got %v", poll, err)
+		}
+		if _, err := os.Stat(src + "/errors/b.txt"); err != nil {
+			t.Errorf("poll %v: expected b.txt moved to errors, but got %v", poll, err)
+		}
+		if _, err := os.Stat(src + "/archive/day/archive/c.txt"); err != nil {
+			t.Errorf("poll %v: expected day/archive/c.txt archived, but got %v", poll, err)
+		}
+		files, _ := ioutil.ReadDir(destination)
+		if len(files) != 12 {
+			t.Errorf("poll %v: expected 12 outputs, but got %d", poll, len(files))
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	_, src, _ := testDirs(t)
+
+	writeBill(src+"/a.txt", content)
+	writeBill(src+"/b.txt", strings.Replace(content, "|Closing           |      3,332,878.00", "|Closing           |      3,332,879.00", 1))
+
+	report, err := Validate(context.Background(), src, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Count(StatusSucceeded) != 1 || report.Count(StatusFailed) != 1 {
+		t.Fatalf("Expected 1 succeeded and 1 failed, but got %v", report.Results)
+	}
+	for _, result := range report.Results {
+		if result.File != "b.txt" {
+			continue
+		}
+		if result.Status != StatusFailed || !strings.Contains(result.Reason, "account 61188803: balance BASE: Closing") ||
+			!strings.Contains(result.Reason, "equity BASE: Equity") {
+			t.Errorf("Expected BASE closing and equity discrepancies, but got %v", result)
+		}
+	}
+
+	os.Remove(src + "/b.txt")
+	if report, err = Validate(context.Background(), src, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	report.Print(&buf)
+	if s := buf.String(); !strings.HasSuffix(s, "INFO: all bills are consistent.\n") {
+		t.Errorf("Expected validate summary, but got %q", s)
+	}
+}
+
+// testDirs a temporary folder of the test with the src and dst folders
+func testDirs(t *testing.T) (string, string, string) {
+	t.Helper()
+	temp := t.TempDir()
+	src, destination := temp+"/src", temp+"/dst"
+	for _, dir := range []string{src, destination} {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return temp, src, destination
+}
+
+// processFile process a bill of src
+func processFile(filename, src, destination string, opts Options) ([]string, string, error) {
+	b, err := ioutil.ReadFile(src + "/" + filename)
+	if err != nil {
+		return nil, "", err
+	}
+	return process(bill{name: filename, dir: path.Dir(filename)}, b, destination, opts, nil)
+}
+
+func writeBill(filepath, content string) error {
+	f, err := os.Create(filepath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := transform.NewWriter(f, simplifiedchinese.GBK.NewEncoder())
+	defer w.Close()
+	_, err = w.Write([]byte(content))
+	return err
 }